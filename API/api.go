@@ -2,45 +2,118 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ahmads/common"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
-	"github.com/google/go-github/v55/github"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
-var dynamoDBClient *dynamodb.DynamoDB
+var dynamoDBClient dynamodbiface.DynamoDBAPI
+
+var metrics = common.NewMetrics()
+var logger = common.NewLogger()
 var eventCountTableName string
 var actorTableName string
 var reposTableName string
+var leaderboardTableName string
+var trendingTableName string
+var rulesTableName string
+var ruleTargets common.RuleTargets
+
+const defaultLeaderboardLimit = 10
+const maxLeaderboardLimit = 100
+
+// Arguments and sort orders of the list fields, the key attributes of the
+// items of each index make up their cursors
+var reposQuery = listQuery{
+	Arguments: []string{"first", "after", "excludeBots", "namePrefix", "eventType", "since", "until", "minStars", "sortBy", "order"},
+	Sorts: map[string]sortIndex{
+		"LAST_ACTIVITY": {Index: common.ReposByLastActivityIndex, RangeKey: "LastActivity", DefaultOrder: "DESC", KeyAttributes: []string{"RepoUrl", "Kind", "LastActivity"}},
+		"NAME":          {Index: common.ReposByNameIndex, RangeKey: "RepoName", DefaultOrder: "ASC", KeyAttributes: []string{"RepoUrl", "Kind", "RepoName"}},
+	},
+	DefaultSort: "LAST_ACTIVITY",
+}
+
+var actorsQuery = listQuery{
+	Arguments: []string{"first", "after", "excludeBots", "namePrefix", "since", "until", "sortBy", "order"},
+	Sorts: map[string]sortIndex{
+		"LAST_ACTION": {Index: common.ActorsByLastActionIndex, RangeKey: "LastAction", DefaultOrder: "DESC", KeyAttributes: []string{"Login", "Kind", "LastAction"}},
+		"LOGIN":       {Index: common.ActorsByLoginIndex, RangeKey: "Login", DefaultOrder: "ASC", KeyAttributes: []string{"Login", "Kind"}},
+	},
+	DefaultSort: "LAST_ACTION",
+}
+
+var eventsQuery = listQuery{
+	Arguments: []string{"first", "after", "excludeBots", "namePrefix", "eventType", "sortBy", "order"},
+	Sorts: map[string]sortIndex{
+		"COUNT": {Index: common.EventsByCountIndex, RangeKey: "Count", DefaultOrder: "DESC", KeyAttributes: []string{"EventType", "Kind", "Count"}},
+		"TYPE":  {Index: common.EventsByTypeIndex, RangeKey: "EventType", DefaultOrder: "ASC", KeyAttributes: []string{"EventType", "Kind"}},
+	},
+	DefaultSort: "COUNT",
+}
+
+// humanCountSort replaces the COUNT sort of the events when bots are excluded
+var humanCountSort = sortIndex{Index: common.EventsByHumanCountIndex, RangeKey: "HumanCount", DefaultOrder: "DESC", KeyAttributes: []string{"EventType", "Kind", "HumanCount"}}
 
 func main() {
 	eventCountTableName = os.Getenv("EVENTS_COUNT_TABLE")
 	if eventCountTableName == "" {
-		fmt.Println("EVENTS_COUNT_TABLE environment variable not set")
+		logger.Error("EVENTS_COUNT_TABLE environment variable not set")
 		os.Exit(1)
 	}
-	fmt.Println("EVENTS_COUNT_TABLE is set to", eventCountTableName)
+	logger.Info("EVENTS_COUNT_TABLE is set", "value", eventCountTableName)
 
 	actorTableName = os.Getenv("ACTORS_TABLE")
 	if actorTableName == "" {
-		fmt.Println("ACTORS_TABLE environment variable not set")
+		logger.Error("ACTORS_TABLE environment variable not set")
 		os.Exit(1)
 	}
-	fmt.Println("ACTORS_TABLE is set to", actorTableName)
+	logger.Info("ACTORS_TABLE is set", "value", actorTableName)
 
 	reposTableName = os.Getenv("REPOS_TABLE")
 	if reposTableName == "" {
-		fmt.Println("reposTableName environment variable not set")
+		logger.Error("reposTableName environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("reposTableName is set", "value", reposTableName)
+
+	leaderboardTableName = os.Getenv("LEADERBOARD_TABLE")
+	if leaderboardTableName == "" {
+		logger.Error("LEADERBOARD_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("LEADERBOARD_TABLE is set", "value", leaderboardTableName)
+
+	trendingTableName = os.Getenv("TRENDING_TABLE")
+	if trendingTableName == "" {
+		logger.Error("TRENDING_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("TRENDING_TABLE is set", "value", trendingTableName)
+
+	rulesTableName = os.Getenv("RULES_TABLE")
+	if rulesTableName == "" {
+		logger.Error("RULES_TABLE environment variable not set")
 		os.Exit(1)
 	}
-	fmt.Println("reposTableName is set to", reposTableName)
+	logger.Info("RULES_TABLE is set", "value", rulesTableName)
+
+	// Without targets, rules can only deliver to webhooks
+	ruleTargets = common.ParseRuleTargets(os.Getenv("RULE_TARGETS"))
+	logger.Info("RULE_TARGETS is set", "value", os.Getenv("RULE_TARGETS"))
 
 	lambda.Start(handler)
 }
@@ -48,25 +121,43 @@ func main() {
 func init() {
 	// Initialize the AWS SDK and DynamoDB client
 	sess := session.Must(session.NewSession())
-	dynamoDBClient = dynamodb.New(sess)
+	client := dynamodb.New(sess)
+	metrics.TrackLatency(&client.Handlers, "DynamoDBLatency")
+	dynamoDBClient = client
 }
 
 type AppSyncResolverEvent struct {
-	Field string `json:"field"`
-	// Arguments map[string]interface{} `json:"arguments"`
+	Field     string                 `json:"field"`
+	Arguments map[string]interface{} `json:"arguments"`
 }
 
 type Repo struct {
-	RepoURL  string `json:"repoURL"`
-	RepoName string `json:"repoName"`
-	RepoId   int64  `json:"repoId"`
-	Stars    int    `json:"stars"`
+	RepoURL       string   `json:"repoURL"`
+	RepoName      string   `json:"repoName"`
+	RepoId        int64    `json:"repoId"`
+	Stars         int      `json:"stars"`
+	Forks         int      `json:"forks"`
+	Language      string   `json:"language"`
+	Topics        []string `json:"topics"`
+	Description   string   `json:"description"`
+	License       string   `json:"license"`
+	OpenIssues    int      `json:"openIssues"`
+	DefaultBranch string   `json:"defaultBranch"`
+	CreatedAt     int64    `json:"createdAt" dynamodbav:"RepoCreatedAt"`
+	RefreshedAt   int64    `json:"refreshedAt"`
 }
 
 type Actor struct {
-	Login string `json:"login"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	Login            string `json:"login"`
+	Name             string `json:"name" dynamodbav:"ActorName"`
+	Email            string `json:"email"`
+	Company          string `json:"company"`
+	Location         string `json:"location"`
+	Followers        int    `json:"followers"`
+	AccountType      string `json:"accountType"`
+	ProfileFetchedAt int64  `json:"profileFetchedAt"`
+	IsBot            bool   `json:"isBot"`
+	BotReason        string `json:"botReason"`
 }
 
 type Event struct {
@@ -74,120 +165,546 @@ type Event struct {
 	Count int    `json:"count"`
 }
 
-func getRepos() (*[]Repo, error) {
-	client := github.NewClient(nil)
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(reposTableName),
+type LeaderboardEntry struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+type Rule struct {
+	RuleId     string `json:"ruleId"`
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+	TargetType string `json:"targetType"`
+	Target     string `json:"target"`
+	CreatedAt  int64  `json:"createdAt"`
+}
+
+type TrendingRepo struct {
+	RepoName string  `json:"repoName"`
+	Score    float64 `json:"score"`
+	Recent   float64 `json:"recent"`
+	Baseline float64 `json:"baseline"`
+}
+
+// getRepos returns a page of repos, by default the most recently active
+// first. Stars and the other GitHub metadata are refreshed by the repoEnricher.
+func getRepos(arguments map[string]interface{}) (*Connection[Repo], error) {
+	query, err := reposQuery.buildQuery(reposTableName, common.KindRepo, arguments)
+	if err != nil {
+		return nil, err
+	}
+	if err := query.prefix("RepoName", arguments); err != nil {
+		return nil, err
+	}
+	if err := query.timeRange("LastActivity", arguments); err != nil {
+		return nil, err
 	}
 
-	result, err := dynamoDBClient.Scan(input)
+	eventType, err := eventTypeArgument(arguments)
 	if err != nil {
 		return nil, err
 	}
+	// Repos count their events per type in Type_<EventType> attributes
+	if eventType != "" {
+		query.filter(fmt.Sprintf("attribute_exists(%s)", query.name("Type_"+eventType)))
+	}
 
-	if result.Items == nil {
-		return nil, errors.New("repos not found")
+	if arguments["minStars"] != nil {
+		minStars, err := intArgument(arguments, "minStars", 0)
+		if err != nil {
+			return nil, err
+		}
+		if minStars < 0 {
+			return nil, fmt.Errorf("minStars must not be negative")
+		}
+		query.filter(fmt.Sprintf("%s >= %s", query.name("Stars"), query.value(":minStars", number(minStars))))
 	}
 
-	repos := []Repo{}
-	for _, i := range result.Items {
+	excludeBots, err := boolArgument(arguments, "excludeBots", false)
+	if err != nil {
+		return nil, err
+	}
+	// Repos only bots acted on have no human events
+	if excludeBots {
+		query.filter(fmt.Sprintf("%s > %s", query.name("HumanEvents"), query.value(":zero", number(0))))
+	}
+
+	page, err := queryPage(query.build(), arguments, query.index.KeyAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := Connection[Repo]{Edges: []Edge[Repo]{}, PageInfo: page.PageInfo}
+	for index, i := range page.Items {
 		repo := Repo{}
 		err = dynamodbattribute.UnmarshalMap(i, &repo)
 		if err != nil {
 			continue
 		}
-		if repo.RepoName != "" && repo.RepoId != 0 {
-			repository, _, err := client.Repositories.GetByID(context.Background(), repo.RepoId)
-			if err == nil {
-				repo.Stars = *repository.StargazersCount
-			}
-		}
-		repos = append(repos, repo)
+		repos.Edges = append(repos.Edges, Edge[Repo]{Node: repo, Cursor: page.Cursors[index]})
 	}
 	return &repos, nil
 }
 
-func getActors() (*[]Actor, error) {
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(actorTableName),
+// getActors returns a page of actors, by default the most recently active first
+func getActors(arguments map[string]interface{}) (*Connection[Actor], error) {
+	query, err := actorsQuery.buildQuery(actorTableName, common.KindActor, arguments)
+	if err != nil {
+		return nil, err
+	}
+	if err := query.prefix("Login", arguments); err != nil {
+		return nil, err
+	}
+	if err := query.timeRange("LastAction", arguments); err != nil {
+		return nil, err
 	}
 
-	result, err := dynamoDBClient.Scan(input)
+	excludeBots, err := boolArgument(arguments, "excludeBots", false)
 	if err != nil {
 		return nil, err
 	}
+	if excludeBots {
+		isBot := query.name("IsBot")
+		query.filter(fmt.Sprintf("attribute_not_exists(%s) OR %s = %s", isBot, isBot, query.value(":false", &dynamodb.AttributeValue{BOOL: aws.Bool(false)})))
+	}
 
-	if result.Items == nil {
-		return nil, errors.New("actors not found")
+	page, err := queryPage(query.build(), arguments, query.index.KeyAttributes)
+	if err != nil {
+		return nil, err
 	}
 
-	actors := []Actor{}
-	for _, i := range result.Items {
+	actors := Connection[Actor]{Edges: []Edge[Actor]{}, PageInfo: page.PageInfo}
+	for index, i := range page.Items {
 		actor := Actor{}
 		err = dynamodbattribute.UnmarshalMap(i, &actor)
 		if err != nil {
 			continue
 		}
-		actors = append(actors, actor)
+		actors.Edges = append(actors.Edges, Edge[Actor]{Node: actor, Cursor: page.Cursors[index]})
 	}
 	return &actors, nil
 }
 
-func getEvents() (*[]Event, error) {
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(eventCountTableName),
+// getEvents returns a page of event types, by default the highest counts first
+func getEvents(arguments map[string]interface{}) (*Connection[Event], error) {
+	query, err := eventsQuery.buildQuery(eventCountTableName, common.KindEvent, arguments)
+	if err != nil {
+		return nil, err
 	}
-	result, err := dynamoDBClient.Scan(input)
+
+	excludeBots, err := boolArgument(arguments, "excludeBots", false)
 	if err != nil {
 		return nil, err
 	}
+	// Events of human actors are ordered by their own count, types only bots
+	// produced are left out
+	if excludeBots {
+		if query.index.RangeKey == "Count" {
+			query.index = humanCountSort
+		}
+		query.filter(fmt.Sprintf("%s > %s", query.name("HumanCount"), query.value(":zero", number(0))))
+	}
 
-	if result.Items == nil {
-		return nil, errors.New("events not found")
+	if err := query.prefix("EventType", arguments); err != nil {
+		return nil, err
 	}
-	events := []Event{}
-	for _, i := range result.Items {
+
+	eventType, err := eventTypeArgument(arguments)
+	if err != nil {
+		return nil, err
+	}
+	if eventType != "" {
+		err = query.condition("EventType", fmt.Sprintf("%s = %s", query.name("EventType"), query.value(":eventType", &dynamodb.AttributeValue{S: aws.String(eventType)})))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page, err := queryPage(query.build(), arguments, query.index.KeyAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	events := Connection[Event]{Edges: []Edge[Event]{}, PageInfo: page.PageInfo}
+	for index, i := range page.Items {
 		event := Event{}
 		err = dynamodbattribute.UnmarshalMap(i, &event)
 		if err != nil {
 			continue
 		}
 		event.Type = *i["EventType"].S
-		events = append(events, event)
+		if excludeBots {
+			event.Count = 0
+			if humanCount, ok := i["HumanCount"]; ok {
+				event.Count, _ = strconv.Atoi(aws.StringValue(humanCount.N))
+			}
+		}
+		events.Edges = append(events.Edges, Edge[Event]{Node: event, Cursor: page.Cursors[index]})
 	}
 
 	return &events, nil
 }
 
+// queryItems follows LastEvaluatedKey across the query pages until limit
+// items matched or the key range is exhausted
+func queryItems(input *dynamodb.QueryInput, limit int) ([]map[string]*dynamodb.AttributeValue, error) {
+	items := []map[string]*dynamodb.AttributeValue{}
+	err := dynamoDBClient.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			items = append(items, i)
+			if len(items) == limit {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// getLeaderboard returns the top members of the dimension's leaderboard for
+// the current window, ordered by count
+func getLeaderboard(dimension string, arguments map[string]interface{}) (*[]LeaderboardEntry, error) {
+	limit, err := intArgument(arguments, "limit", defaultLeaderboardLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > maxLeaderboardLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxLeaderboardLimit)
+	}
+
+	window, err := stringArgument(arguments, "window", common.WindowAll)
+	if err != nil {
+		return nil, err
+	}
+	excludeBots, err := boolArgument(arguments, "excludeBots", false)
+	if err != nil {
+		return nil, err
+	}
+	if excludeBots {
+		dimension = common.HumanOnly(dimension)
+	}
+
+	board, err := common.BoardKey(dimension, window, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(leaderboardTableName),
+		IndexName:              aws.String(common.LeaderboardByCountIndex),
+		KeyConditionExpression: aws.String("Board = :board"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":board": {S: aws.String(board)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	}
+	result, err := dynamoDBClient.Query(input)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []LeaderboardEntry{}
+	for _, i := range result.Items {
+		entry := LeaderboardEntry{}
+		err = dynamodbattribute.UnmarshalMap(i, &entry)
+		if err != nil {
+			continue
+		}
+		entry.Key = *i["Member"].S
+		entries = append(entries, entry)
+	}
+
+	return &entries, nil
+}
+
+// getTrendingRepos returns the highest trending scores computed by the
+// trending aggregator for the window, skipping results of past runs which
+// were not yet removed by the table TTL
+func getTrendingRepos(arguments map[string]interface{}) (*[]TrendingRepo, error) {
+	limit, err := intArgument(arguments, "limit", defaultLeaderboardLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > maxLeaderboardLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxLeaderboardLimit)
+	}
+
+	window, err := stringArgument(arguments, "window", common.WindowDay)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := common.GetTrendWindow(window); err != nil {
+		return nil, err
+	}
+
+	excludeBots, err := boolArgument(arguments, "excludeBots", false)
+	if err != nil {
+		return nil, err
+	}
+	if excludeBots {
+		window = common.HumanOnly(window)
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(trendingTableName),
+		IndexName:              aws.String("ScoreIndex"),
+		KeyConditionExpression: aws.String("#window = :window"),
+		FilterExpression:       aws.String("ExpiresAt > :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#window": aws.String("Window"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":window": {S: aws.String(window)},
+			":now":    {N: aws.String(fmt.Sprintf("%d", time.Now().Unix()))},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+
+	items, err := queryItems(input, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := []TrendingRepo{}
+	for _, i := range items {
+		repo := TrendingRepo{}
+		err = dynamodbattribute.UnmarshalMap(i, &repo)
+		if err != nil {
+			continue
+		}
+		repos = append(repos, repo)
+	}
+
+	return &repos, nil
+}
+
+func getRules() (*[]Rule, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(rulesTableName),
+	}
+
+	rules := []Rule{}
+	err := dynamoDBClient.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			rule := Rule{}
+			err := dynamodbattribute.UnmarshalMap(i, &rule)
+			if err != nil {
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// createRule validates the rule expression and target before saving the
+// rule, the consumer picks it up within a minute
+func createRule(arguments map[string]interface{}) (*Rule, error) {
+	rule := Rule{CreatedAt: time.Now().Unix()}
+	var err error
+	if rule.Name, err = stringArgument(arguments, "name", ""); err != nil {
+		return nil, err
+	}
+	if rule.Expression, err = stringArgument(arguments, "expression", ""); err != nil {
+		return nil, err
+	}
+	if rule.TargetType, err = stringArgument(arguments, "targetType", ""); err != nil {
+		return nil, err
+	}
+	if rule.Target, err = stringArgument(arguments, "target", ""); err != nil {
+		return nil, err
+	}
+
+	if err := common.ValidateRuleName(rule.Name); err != nil {
+		return nil, err
+	}
+	if _, err := common.ParseRule(rule.Expression); err != nil {
+		return nil, fmt.Errorf("invalid rule expression: %w", err)
+	}
+	if err := validateTarget(rule.TargetType, rule.Target); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	rule.RuleId = hex.EncodeToString(id)
+
+	item, err := dynamodbattribute.MarshalMap(map[string]interface{}{
+		"RuleId":     rule.RuleId,
+		"Name":       rule.Name,
+		"Expression": rule.Expression,
+		"TargetType": rule.TargetType,
+		"Target":     rule.Target,
+		"CreatedAt":  rule.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = dynamoDBClient.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(rulesTableName),
+		Item:      item,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func validateTarget(targetType string, target string) error {
+	switch targetType {
+	case common.TargetSNS:
+		if !strings.HasPrefix(target, "arn:aws:sns:") {
+			return errors.New("SNS target must be a topic ARN")
+		}
+		if !ruleTargets[target] {
+			return fmt.Errorf("topic %s is not an allowed rule target", target)
+		}
+	case common.TargetSQS:
+		if !strings.HasPrefix(target, "https://sqs.") {
+			return errors.New("SQS target must be a queue URL")
+		}
+		if !ruleTargets[target] {
+			return fmt.Errorf("queue %s is not an allowed rule target", target)
+		}
+	case common.TargetWebhook:
+		return common.ValidateWebhookTarget(target)
+	default:
+		return fmt.Errorf("target type must be one of %s", strings.Join(common.TargetTypes, ", "))
+	}
+	return nil
+}
+
+// deleteRule returns whether a rule was deleted
+func deleteRule(arguments map[string]interface{}) (bool, error) {
+	ruleId, err := stringArgument(arguments, "ruleId", "")
+	if err != nil {
+		return false, err
+	}
+	if ruleId == "" {
+		return false, errors.New("ruleId is required")
+	}
+
+	result, err := dynamoDBClient.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(rulesTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"RuleId": {S: aws.String(ruleId)},
+		},
+		ReturnValues: aws.String("ALL_OLD"),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(result.Attributes) > 0, nil
+}
+
+// intArgument reads an optional integer argument, AppSync passes JSON numbers
+func intArgument(arguments map[string]interface{}, name string, defaultValue int) (int, error) {
+	value, ok := arguments[name]
+	if !ok || value == nil {
+		return defaultValue, nil
+	}
+	number, ok := value.(float64)
+	if !ok || number != float64(int(number)) {
+		return 0, fmt.Errorf("argument %s must be an integer", name)
+	}
+	return int(number), nil
+}
+
+func boolArgument(arguments map[string]interface{}, name string, defaultValue bool) (bool, error) {
+	value, ok := arguments[name]
+	if !ok || value == nil {
+		return defaultValue, nil
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("argument %s must be a boolean", name)
+	}
+	return b, nil
+}
+
+func stringArgument(arguments map[string]interface{}, name string, defaultValue string) (string, error) {
+	value, ok := arguments[name]
+	if !ok || value == nil {
+		return defaultValue, nil
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %s must be a string", name)
+	}
+	return str, nil
+}
+
 func handler(ctx context.Context, event json.RawMessage) (interface{}, error) {
-	fmt.Println("Received event:", string(event))
+	defer metrics.Flush()
+	log := common.RequestLogger(ctx, logger)
 	var resolverEvent AppSyncResolverEvent
 	if err := json.Unmarshal(event, &resolverEvent); err != nil {
+		log.Error("invalid resolver event", "event", string(event), "error", err)
 		return nil, err
 	}
+	log = log.With("field", resolverEvent.Field)
+	log.Debug("received event", "event", string(event))
 
-	fieldName := resolverEvent.Field
+	start := time.Now()
+	result, err := resolve(resolverEvent)
+	field := common.Dimension{Name: "Field", Value: resolverEvent.Field}
+	metrics.Milliseconds("ResolverLatency", time.Since(start), field)
+	if err != nil {
+		metrics.Count("ResolverErrors", 1, field)
+		log.Error("failed to resolve field", "error", err)
+	}
+	return result, err
+}
 
-	switch fieldName {
+// resolve answers the query or mutation of the field
+func resolve(resolverEvent AppSyncResolverEvent) (interface{}, error) {
+	switch resolverEvent.Field {
 	case "Repos":
-		repos, err := getRepos()
+		repos, err := getRepos(resolverEvent.Arguments)
 		if err != nil {
 			return nil, err
 		}
 		return repos, nil
 	case "Actors":
 
-		actors, err := getActors()
+		actors, err := getActors(resolverEvent.Arguments)
 		if err != nil {
 			return nil, err
 		}
 		return actors, nil
 	case "Events":
-		events, err := getEvents()
+		events, err := getEvents(resolverEvent.Arguments)
 		if err != nil {
 			return nil, err
 		}
 		return events, nil
+	case "topRepos":
+		return getLeaderboard(common.BoardRepos, resolverEvent.Arguments)
+	case "topActors":
+		return getLeaderboard(common.BoardActors, resolverEvent.Arguments)
+	case "topEventTypes":
+		return getLeaderboard(common.BoardEventTypes, resolverEvent.Arguments)
+	case "eventsByLanguage":
+		return getLeaderboard(common.BoardLanguages, resolverEvent.Arguments)
+	case "trendingRepos":
+		return getTrendingRepos(resolverEvent.Arguments)
+	case "rules":
+		return getRules()
+	case "createRule":
+		return createRule(resolverEvent.Arguments)
+	case "deleteRule":
+		return deleteRule(resolverEvent.Arguments)
 	default:
 		return nil, errors.New("invalid request")
 	}
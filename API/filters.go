@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const maxPrefixLength = 256
+
+var eventTypePattern = regexp.MustCompile(`^[A-Za-z]+$`)
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+// sortIndex is an ordered index a list can be sorted by
+type sortIndex struct {
+	Index         string
+	RangeKey      string
+	DefaultOrder  string
+	KeyAttributes []string
+}
+
+// listQuery describes the arguments a list field accepts and the orders it
+// can be sorted in
+type listQuery struct {
+	Arguments   []string
+	Sorts       map[string]sortIndex
+	DefaultSort string
+}
+
+// buildQuery validates the arguments against the allow-list and starts a
+// query on the index of the requested sort order
+func (q listQuery) buildQuery(tableName string, kind string, arguments map[string]interface{}) (*queryBuilder, error) {
+	for name := range arguments {
+		allowed := false
+		for _, argument := range q.Arguments {
+			allowed = allowed || argument == name
+		}
+		if !allowed {
+			return nil, fmt.Errorf("unknown argument %s", name)
+		}
+	}
+
+	sortBy, err := stringArgument(arguments, "sortBy", q.DefaultSort)
+	if err != nil {
+		return nil, err
+	}
+	index, ok := q.Sorts[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("invalid sortBy %s", sortBy)
+	}
+
+	order, err := stringArgument(arguments, "order", index.DefaultOrder)
+	if err != nil {
+		return nil, err
+	}
+	if order != "ASC" && order != "DESC" {
+		return nil, fmt.Errorf("invalid order %s", order)
+	}
+
+	builder := &queryBuilder{
+		tableName: tableName,
+		index:     index,
+		order:     order,
+		names:     map[string]*string{"#kind": aws.String("Kind")},
+		values: map[string]*dynamodb.AttributeValue{
+			":kind": {S: aws.String(kind)},
+		},
+	}
+	return builder, nil
+}
+
+// queryBuilder translates filters into the key condition when they apply to
+// the range key of the queried index, and into the filter expression otherwise
+type queryBuilder struct {
+	tableName    string
+	index        sortIndex
+	order        string
+	rangeKeyCond string
+	filters      []string
+	names        map[string]*string
+	values       map[string]*dynamodb.AttributeValue
+}
+
+func (b *queryBuilder) name(attribute string) string {
+	placeholder := "#" + strings.ToLower(nonAlphanumeric.ReplaceAllString(attribute, ""))
+	b.names[placeholder] = aws.String(attribute)
+	return placeholder
+}
+
+func (b *queryBuilder) value(placeholder string, value *dynamodb.AttributeValue) string {
+	b.values[placeholder] = value
+	return placeholder
+}
+
+// condition adds a condition on the attribute, DynamoDB only allows one
+// condition on the range key
+func (b *queryBuilder) condition(attribute string, condition string) error {
+	if attribute != b.index.RangeKey {
+		b.filters = append(b.filters, condition)
+		return nil
+	}
+	if b.rangeKeyCond != "" {
+		return fmt.Errorf("only one filter on %s can be used when sorting by it", attribute)
+	}
+	b.rangeKeyCond = condition
+	return nil
+}
+
+// filter adds a condition which never applies to the range key
+func (b *queryBuilder) filter(condition string) {
+	b.filters = append(b.filters, condition)
+}
+
+// prefix filters on attributes beginning with the namePrefix argument
+func (b *queryBuilder) prefix(attribute string, arguments map[string]interface{}) error {
+	prefix, err := stringArgument(arguments, "namePrefix", "")
+	if err != nil || prefix == "" {
+		return err
+	}
+	if len(prefix) > maxPrefixLength {
+		return fmt.Errorf("namePrefix must be at most %d characters", maxPrefixLength)
+	}
+	return b.condition(attribute, fmt.Sprintf("begins_with(%s, %s)", b.name(attribute), b.value(":prefix", &dynamodb.AttributeValue{S: aws.String(prefix)})))
+}
+
+// timeRange filters on the attribute, a unix timestamp, being within the
+// since and until arguments
+func (b *queryBuilder) timeRange(attribute string, arguments map[string]interface{}) error {
+	since, err := intArgument(arguments, "since", -1)
+	if err != nil {
+		return err
+	}
+	until, err := intArgument(arguments, "until", -1)
+	if err != nil {
+		return err
+	}
+	if (arguments["since"] != nil && since < 0) || (arguments["until"] != nil && until < 0) {
+		return fmt.Errorf("since and until must be unix timestamps")
+	}
+
+	if since < 0 && until < 0 {
+		return nil
+	}
+	// DynamoDB rejects names the expressions do not use
+	name := b.name(attribute)
+	switch {
+	case since >= 0 && until >= 0:
+		if since > until {
+			return fmt.Errorf("since must be before until")
+		}
+		return b.condition(attribute, fmt.Sprintf("%s BETWEEN %s AND %s", name, b.value(":since", number(since)), b.value(":until", number(until))))
+	case since >= 0:
+		return b.condition(attribute, fmt.Sprintf("%s >= %s", name, b.value(":since", number(since))))
+	case until >= 0:
+		return b.condition(attribute, fmt.Sprintf("%s <= %s", name, b.value(":until", number(until))))
+	}
+	return nil
+}
+
+// eventTypeArgument returns the validated eventType argument
+func eventTypeArgument(arguments map[string]interface{}) (string, error) {
+	eventType, err := stringArgument(arguments, "eventType", "")
+	if err != nil {
+		return "", err
+	}
+	if eventType != "" && !eventTypePattern.MatchString(eventType) {
+		return "", fmt.Errorf("invalid eventType %s", eventType)
+	}
+	return eventType, nil
+}
+
+func (b *queryBuilder) build() *dynamodb.QueryInput {
+	keyCondition := "#kind = :kind"
+	if b.rangeKeyCond != "" {
+		keyCondition += " AND " + b.rangeKeyCond
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(b.tableName),
+		IndexName:                 aws.String(b.index.Index),
+		KeyConditionExpression:    aws.String(keyCondition),
+		ExpressionAttributeNames:  b.names,
+		ExpressionAttributeValues: b.values,
+		ScanIndexForward:          aws.Bool(b.order == "ASC"),
+	}
+	if len(b.filters) > 0 {
+		input.FilterExpression = aws.String("(" + strings.Join(b.filters, ") AND (") + ")")
+	}
+	return input
+}
+
+func number(n int) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", n))}
+}
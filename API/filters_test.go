@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestBuildQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     listQuery
+		arguments map[string]interface{}
+		// Index and order of the query, or the error
+		index, order, err string
+	}{
+		{"defaults", reposQuery, map[string]interface{}{}, "ByLastActivity", "DESC", ""},
+		{"every argument", reposQuery, map[string]interface{}{
+			"first": 10.0, "after": "", "excludeBots": true, "namePrefix": "org/", "eventType": "PushEvent",
+			"since": 1.0, "until": 2.0, "minStars": 5.0, "sortBy": "NAME", "order": "DESC",
+		}, "ByName", "DESC", ""},
+		{"null arguments take the defaults", actorsQuery, map[string]interface{}{"sortBy": nil, "order": nil}, "ByLastAction", "DESC", ""},
+		{"sort default order", eventsQuery, map[string]interface{}{"sortBy": "TYPE"}, "ByType", "ASC", ""},
+		{"unknown argument", reposQuery, map[string]interface{}{"limit": 10.0}, "", "", "unknown argument limit"},
+		{"argument of another list", actorsQuery, map[string]interface{}{"minStars": 10.0}, "", "", "unknown argument minStars"},
+		{"argument of another list", eventsQuery, map[string]interface{}{"since": 10.0}, "", "", "unknown argument since"},
+		{"unknown sort", reposQuery, map[string]interface{}{"sortBy": "STARS"}, "", "", "invalid sortBy STARS"},
+		{"sort of another list", actorsQuery, map[string]interface{}{"sortBy": "NAME"}, "", "", "invalid sortBy NAME"},
+		{"sortBy of another type", reposQuery, map[string]interface{}{"sortBy": 1.0}, "", "", "must be a string"},
+		{"unknown order", reposQuery, map[string]interface{}{"order": "asc"}, "", "", "invalid order asc"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder, err := test.query.buildQuery("table", "REPO", test.arguments)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			input := builder.build()
+			if got := aws.StringValue(input.IndexName); got != test.index {
+				t.Errorf("index = %s", got)
+			}
+			if got := aws.BoolValue(input.ScanIndexForward); got != (test.order == "ASC") {
+				t.Errorf("scan index forward = %v", got)
+			}
+		})
+	}
+}
+
+func TestQueryBuilderFilters(t *testing.T) {
+	tests := []struct {
+		name      string
+		sortBy    string
+		arguments map[string]interface{}
+		// Key condition and filter expression of the query, or the error
+		keyCondition, filter, err string
+	}{
+		{"no filter", "LAST_ACTIVITY", map[string]interface{}{}, "#kind = :kind", "", ""},
+		{"range on the sorted attribute", "LAST_ACTIVITY", map[string]interface{}{"since": 10.0, "until": 20.0},
+			"#kind = :kind AND #lastactivity BETWEEN :since AND :until", "", ""},
+		{"since on the sorted attribute", "LAST_ACTIVITY", map[string]interface{}{"since": 10.0}, "#kind = :kind AND #lastactivity >= :since", "", ""},
+		{"until on another attribute", "NAME", map[string]interface{}{"until": 20.0}, "#kind = :kind", "(#lastactivity <= :until)", ""},
+		{"prefix on the sorted attribute", "NAME", map[string]interface{}{"namePrefix": "org/"}, "#kind = :kind AND begins_with(#reponame, :prefix)", "", ""},
+		{"prefix and range", "LAST_ACTIVITY", map[string]interface{}{"namePrefix": "org/", "since": 10.0},
+			"#kind = :kind AND #lastactivity >= :since", "(begins_with(#reponame, :prefix))", ""},
+		{"two conditions on the sorted attribute", "NAME", map[string]interface{}{"namePrefix": "org/", "until": 20.0},
+			"#kind = :kind AND begins_with(#reponame, :prefix)", "(#lastactivity <= :until)", ""},
+		{"empty prefix", "NAME", map[string]interface{}{"namePrefix": ""}, "#kind = :kind", "", ""},
+		{"prefix too long", "NAME", map[string]interface{}{"namePrefix": strings.Repeat("a", maxPrefixLength+1)}, "", "", "namePrefix must be at most 256 characters"},
+		{"negative since", "LAST_ACTIVITY", map[string]interface{}{"since": -1.0}, "", "", "must be unix timestamps"},
+		{"since after until", "LAST_ACTIVITY", map[string]interface{}{"since": 20.0, "until": 10.0}, "", "", "since must be before until"},
+		{"fractional timestamp", "LAST_ACTIVITY", map[string]interface{}{"since": 1.5}, "", "", "must be an integer"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			arguments := map[string]interface{}{"sortBy": test.sortBy}
+			for name, value := range test.arguments {
+				arguments[name] = value
+			}
+			builder, err := reposQuery.buildQuery("table", "REPO", arguments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = builder.prefix("RepoName", arguments)
+			if err == nil {
+				err = builder.timeRange("LastActivity", arguments)
+			}
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			input := builder.build()
+			if got := aws.StringValue(input.KeyConditionExpression); got != test.keyCondition {
+				t.Errorf("key condition = %s, want %s", got, test.keyCondition)
+			}
+			if got := aws.StringValue(input.FilterExpression); got != test.filter {
+				t.Errorf("filter = %s, want %s", got, test.filter)
+			}
+			// Every placeholder of the expressions is defined
+			expressions := aws.StringValue(input.KeyConditionExpression) + " " + aws.StringValue(input.FilterExpression)
+			for placeholder := range input.ExpressionAttributeNames {
+				if !strings.Contains(expressions, placeholder) {
+					t.Errorf("unused name %s", placeholder)
+				}
+			}
+			for placeholder := range input.ExpressionAttributeValues {
+				if !strings.Contains(expressions, placeholder) {
+					t.Errorf("unused value %s", placeholder)
+				}
+			}
+		})
+	}
+}
+
+func TestEventTypeArgument(t *testing.T) {
+	tests := map[string]bool{
+		"":                    true,
+		"PushEvent":           true,
+		"Push Event":          false,
+		"PushEvent) OR (Kind": false,
+		"Push_Event":          false,
+	}
+	for eventType, valid := range tests {
+		_, err := eventTypeArgument(map[string]interface{}{"eventType": eventType})
+		if (err == nil) != valid {
+			t.Errorf("eventType %q error = %v", eventType, err)
+		}
+	}
+}
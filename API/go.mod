@@ -3,13 +3,11 @@ module api
 go 1.21.1
 
 require (
-	github.com/ProtonMail/go-crypto v0.0.0-20230217124315-7d5c6f04bbb8 // indirect
-	github.com/aws/aws-lambda-go v1.41.0 // indirect
-	github.com/aws/aws-sdk-go v1.45.11 // indirect
-	github.com/cloudflare/circl v1.3.3 // indirect
-	github.com/google/go-github/v55 v55.0.0 // indirect
-	github.com/google/go-querystring v1.1.0 // indirect
-	github.com/jmespath/go-jmespath v0.4.0 // indirect
-	golang.org/x/crypto v0.12.0 // indirect
-	golang.org/x/sys v0.11.0 // indirect
+	github.com/ahmads/common v0.0.0
+	github.com/aws/aws-lambda-go v1.41.0
+	github.com/aws/aws-sdk-go v1.45.11
 )
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
+
+replace github.com/ahmads/common => ../common
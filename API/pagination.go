@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const defaultPageSize = 20
+const maxPageSize = 100
+
+// Queries reading a page stop after this many round trips, filtered queries
+// evaluate up to maxPageSize items a round trip
+const maxQueryRoundTrips = 10
+
+type PageInfo struct {
+	HasNextPage bool    `json:"hasNextPage"`
+	EndCursor   *string `json:"endCursor"`
+}
+
+type Edge[T any] struct {
+	Node   T      `json:"node"`
+	Cursor string `json:"cursor"`
+}
+
+type Connection[T any] struct {
+	Edges    []Edge[T] `json:"edges"`
+	PageInfo PageInfo  `json:"pageInfo"`
+}
+
+// page is one page of query results with the cursor of each item
+type page struct {
+	Items    []map[string]*dynamodb.AttributeValue
+	Cursors  []string
+	PageInfo PageInfo
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor wraps the key attributes of an item, the table key and the
+// index key, into an opaque cursor DynamoDB can resume the query after
+func encodeCursor(item map[string]*dynamodb.AttributeValue, keyAttributes []string) (string, error) {
+	key := map[string]map[string]string{}
+	for _, name := range keyAttributes {
+		value, ok := item[name]
+		if !ok {
+			return "", fmt.Errorf("item has no key attribute %s", name)
+		}
+		switch {
+		case value.S != nil:
+			key[name] = map[string]string{"S": *value.S}
+		case value.N != nil:
+			key[name] = map[string]string{"N": *value.N}
+		default:
+			return "", fmt.Errorf("key attribute %s is neither a string nor a number", name)
+		}
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor returns the ExclusiveStartKey wrapped by the cursor, checking
+// it holds the key attributes of the queried index
+func decodeCursor(cursor string, keyAttributes []string) (map[string]*dynamodb.AttributeValue, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	key := map[string]map[string]string{}
+	if err := json.Unmarshal(data, &key); err != nil || len(key) != len(keyAttributes) {
+		return nil, errInvalidCursor
+	}
+
+	startKey := map[string]*dynamodb.AttributeValue{}
+	for _, name := range keyAttributes {
+		value, ok := key[name]
+		if !ok || len(value) != 1 {
+			return nil, errInvalidCursor
+		}
+		if s, ok := value["S"]; ok {
+			startKey[name] = &dynamodb.AttributeValue{S: aws.String(s)}
+		} else if n, ok := value["N"]; ok {
+			startKey[name] = &dynamodb.AttributeValue{N: aws.String(n)}
+		} else {
+			return nil, errInvalidCursor
+		}
+	}
+	return startKey, nil
+}
+
+// queryPage reads the page of the query described by the first and after
+// arguments, following LastEvaluatedKey until the page is full, the key
+// range is exhausted or maxQueryRoundTrips were made. Filter expressions make
+// pages read more items than they return, a page cut short by the round
+// trips has a next page starting after the last item evaluated.
+func queryPage(input *dynamodb.QueryInput, arguments map[string]interface{}, keyAttributes []string) (*page, error) {
+	first, err := intArgument(arguments, "first", defaultPageSize)
+	if err != nil {
+		return nil, err
+	}
+	if first < 1 || first > maxPageSize {
+		return nil, fmt.Errorf("first must be between 1 and %d", maxPageSize)
+	}
+
+	after, err := stringArgument(arguments, "after", "")
+	if err != nil {
+		return nil, err
+	}
+	if after != "" {
+		input.ExclusiveStartKey, err = decodeCursor(after, keyAttributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &page{
+		Items:   []map[string]*dynamodb.AttributeValue{},
+		Cursors: []string{},
+	}
+	for roundTrips := 1; ; roundTrips++ {
+		limit := first - len(result.Items)
+		if input.FilterExpression != nil {
+			limit = maxPageSize
+		}
+		input.Limit = aws.Int64(int64(limit))
+		output, err := dynamoDBClient.Query(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range output.Items {
+			if len(result.Items) == first {
+				// The page filled up before the end of the items read
+				result.PageInfo.HasNextPage = true
+				break
+			}
+			cursor, err := encodeCursor(i, keyAttributes)
+			if err != nil {
+				return nil, err
+			}
+			result.Items = append(result.Items, i)
+			result.Cursors = append(result.Cursors, cursor)
+		}
+
+		if result.PageInfo.HasNextPage || len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		if len(result.Items) == first {
+			result.PageInfo.HasNextPage = true
+			break
+		}
+		if roundTrips == maxQueryRoundTrips {
+			cursor, err := encodeCursor(output.LastEvaluatedKey, keyAttributes)
+			if err != nil {
+				return nil, err
+			}
+			result.PageInfo.HasNextPage = true
+			result.PageInfo.EndCursor = aws.String(cursor)
+			return result, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+
+	if len(result.Cursors) > 0 {
+		result.PageInfo.EndCursor = aws.String(result.Cursors[len(result.Cursors)-1])
+	}
+	return result, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var repoKey = []string{"RepoUrl", "Kind", "LastActivity"}
+
+func repoItem(n int) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"RepoUrl":      {S: aws.String(fmt.Sprintf("https://api.github.com/repos/org/repo%d", n))},
+		"Kind":         {S: aws.String("REPO")},
+		"LastActivity": {N: aws.String(fmt.Sprint(1700000000 - n))},
+		"RepoName":     {S: aws.String(fmt.Sprintf("org/repo%d", n))},
+	}
+}
+
+func TestCursor(t *testing.T) {
+	item := repoItem(1)
+	cursor, err := encodeCursor(item, repoKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startKey, err := decodeCursor(cursor, repoKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the key attributes make up the cursor
+	want := map[string]*dynamodb.AttributeValue{"RepoUrl": item["RepoUrl"], "Kind": item["Kind"], "LastActivity": item["LastActivity"]}
+	if !reflect.DeepEqual(startKey, want) {
+		t.Errorf("start key = %v, want %v", startKey, want)
+	}
+
+	if _, err := encodeCursor(item, []string{"RepoUrl", "Stars"}); err == nil {
+		t.Error("encoded an item missing a key attribute")
+	}
+	if _, err := encodeCursor(map[string]*dynamodb.AttributeValue{"RepoUrl": {BOOL: aws.Bool(true)}}, []string{"RepoUrl"}); err == nil {
+		t.Error("encoded a boolean key attribute")
+	}
+
+	loginCursor, err := encodeCursor(map[string]*dynamodb.AttributeValue{"Login": {S: aws.String("octocat")}, "Kind": {S: aws.String("ACTOR")}}, []string{"Login", "Kind"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := func(key string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(key))
+	}
+	tests := map[string]string{
+		"not base64":                "not base64!",
+		"not JSON":                  raw(`not JSON`),
+		"cursor of another index":   loginCursor,
+		"missing a key attribute":   raw(`{"RepoUrl":{"S":"u"},"Kind":{"S":"REPO"}}`),
+		"extra attribute":           raw(`{"RepoUrl":{"S":"u"},"Kind":{"S":"REPO"},"LastActivity":{"N":"1"},"Stars":{"N":"1"}}`),
+		"attribute of another type": raw(`{"RepoUrl":{"S":"u"},"Kind":{"S":"REPO"},"LastActivity":{"BOOL":"true"}}`),
+		"several types":             raw(`{"RepoUrl":{"S":"u"},"Kind":{"S":"REPO"},"LastActivity":{"N":"1","S":"1"}}`),
+	}
+	for name, cursor := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decodeCursor(cursor, repoKey); err != errInvalidCursor {
+				t.Errorf("error = %v", err)
+			}
+		})
+	}
+}
+
+// fakeQueries answers the queries with its outputs in turn
+type fakeQueries struct {
+	dynamodbiface.DynamoDBAPI
+	outputs []*dynamodb.QueryOutput
+	inputs  []dynamodb.QueryInput
+}
+
+func (f *fakeQueries) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	f.inputs = append(f.inputs, *input)
+	if len(f.inputs) > len(f.outputs) {
+		return nil, fmt.Errorf("unexpected query %d", len(f.inputs))
+	}
+	return f.outputs[len(f.inputs)-1], nil
+}
+
+func TestQueryPage(t *testing.T) {
+	cursor := func(n int) string {
+		cursor, _ := encodeCursor(repoItem(n), repoKey)
+		return cursor
+	}
+	items := func(from, to int) []map[string]*dynamodb.AttributeValue {
+		var items []map[string]*dynamodb.AttributeValue
+		for n := from; n < to; n++ {
+			items = append(items, repoItem(n))
+		}
+		return items
+	}
+	// Filtered out items leave a page short of items with more to read
+	filtered := func(n int) *dynamodb.QueryOutput {
+		return &dynamodb.QueryOutput{LastEvaluatedKey: repoItem(n)}
+	}
+	var exhausting []*dynamodb.QueryOutput
+	for n := 0; n < maxQueryRoundTrips; n++ {
+		exhausting = append(exhausting, filtered(n))
+	}
+
+	tests := []struct {
+		name        string
+		first       int
+		filter      bool
+		outputs     []*dynamodb.QueryOutput
+		items       int
+		hasNextPage bool
+		endCursor   string
+		limits      []int64
+	}{
+		{"last page", 5, false, []*dynamodb.QueryOutput{{Items: items(0, 3)}}, 3, false, cursor(2), []int64{5}},
+		{"full page", 3, false, []*dynamodb.QueryOutput{{Items: items(0, 3), LastEvaluatedKey: repoItem(2)}}, 3, true, cursor(2), []int64{3}},
+		{"empty", 3, false, []*dynamodb.QueryOutput{{}}, 0, false, "", []int64{3}},
+		{"filtered pages read the page size", 3, true, []*dynamodb.QueryOutput{
+			{Items: items(0, 1), LastEvaluatedKey: repoItem(50)},
+			{Items: items(1, 2)},
+		}, 2, false, cursor(1), []int64{maxPageSize, maxPageSize}},
+		{"filtered page filling up within a read", 2, true, []*dynamodb.QueryOutput{{Items: items(0, 5), LastEvaluatedKey: repoItem(60)}}, 2, true, cursor(1), []int64{maxPageSize}},
+		{"round trips exhausted", 3, true, exhausting, 0, true, cursor(maxQueryRoundTrips - 1), nil},
+		{"round trips exhausted after some items", 3, true, append([]*dynamodb.QueryOutput{{Items: items(0, 1), LastEvaluatedKey: repoItem(0)}}, exhausting[1:]...), 1, true, cursor(maxQueryRoundTrips - 1), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeQueries{outputs: test.outputs}
+			dynamoDBClient = fake
+			input := &dynamodb.QueryInput{}
+			if test.filter {
+				input.FilterExpression = aws.String("Stars >= :minStars")
+			}
+
+			result, err := queryPage(input, map[string]interface{}{"first": float64(test.first)}, repoKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Items) != test.items || len(result.Cursors) != test.items {
+				t.Errorf("%d items and %d cursors, want %d", len(result.Items), len(result.Cursors), test.items)
+			}
+			if result.PageInfo.HasNextPage != test.hasNextPage {
+				t.Errorf("hasNextPage = %v", result.PageInfo.HasNextPage)
+			}
+			if got := aws.StringValue(result.PageInfo.EndCursor); got != test.endCursor {
+				t.Errorf("endCursor = %s, want %s", got, test.endCursor)
+			}
+			if len(fake.inputs) > maxQueryRoundTrips {
+				t.Errorf("%d round trips", len(fake.inputs))
+			}
+			for i, limit := range test.limits {
+				if got := aws.Int64Value(fake.inputs[i].Limit); got != limit {
+					t.Errorf("round trip %d limit = %d, want %d", i, got, limit)
+				}
+			}
+			// Every round trip resumes after the previous one
+			for i := 1; i < len(fake.inputs); i++ {
+				if !reflect.DeepEqual(fake.inputs[i].ExclusiveStartKey, test.outputs[i-1].LastEvaluatedKey) {
+					t.Errorf("round trip %d does not resume after the previous one", i)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryPageArguments(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments map[string]interface{}
+	}{
+		{"first too small", map[string]interface{}{"first": float64(0)}},
+		{"first too large", map[string]interface{}{"first": float64(maxPageSize + 1)}},
+		{"first not an integer", map[string]interface{}{"first": 1.5}},
+		{"invalid after", map[string]interface{}{"after": "???"}},
+		{"after of another type", map[string]interface{}{"after": 1.0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeQueries{}
+			dynamoDBClient = fake
+			if _, err := queryPage(&dynamodb.QueryInput{}, test.arguments, repoKey); err == nil {
+				t.Error("no error")
+			}
+			if len(fake.inputs) != 0 {
+				t.Error("queried with invalid arguments")
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/go-github/v55/github"
+)
+
+var actorTableName string
+
+var db *dynamodb.DynamoDB
+var metrics = common.NewMetrics()
+var logger = common.NewLogger()
+var client *github.Client
+
+// Profiles fetched more recently than this are not fetched again
+const profileTTL = 7 * 24 * time.Hour
+
+// GitHub requests left to other consumers of the token, once reached the
+// remaining logins of the batch are retried by SQS later
+const rateLimitReserve = 500
+
+var errRateLimited = errors.New("github rate limit reserve reached")
+
+// fetchedAt caches when the logins were enriched by this container, saving a
+// DynamoDB read for logins requested again shortly after
+var fetchedAt = map[string]time.Time{}
+
+func main() {
+	actorTableName = os.Getenv("ACTORS_TABLE")
+	if actorTableName == "" {
+		logger.Error("ACTORS_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("ACTORS_TABLE is set", "value", actorTableName)
+
+	sess := session.Must(session.NewSession())
+	client = github.NewClient(nil)
+	// Unauthenticated requests are limited to 60 an hour
+	if tokenParameter := os.Getenv("GITHUB_TOKEN_PARAMETER"); tokenParameter != "" {
+		token, err := common.ReadSecret(sess, tokenParameter)
+		if err != nil {
+			logger.Error("failed to read the GitHub token", "parameter", tokenParameter, "error", err)
+			os.Exit(1)
+		}
+		client = client.WithAuthToken(token)
+	} else {
+		logger.Warn("GITHUB_TOKEN_PARAMETER is not set, using unauthenticated requests")
+	}
+
+	db = dynamodb.New(sess)
+	metrics.TrackLatency(&db.Handlers, "DynamoDBLatency")
+
+	lambda.Start(handler)
+}
+
+// handler enriches the requested logins, reporting the ones it could not
+// enrich as batch item failures so SQS delivers them again
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	defer metrics.Flush()
+	response := events.SQSEventResponse{}
+	rateLimited := false
+	requestLog := common.RequestLogger(ctx, logger)
+
+	for _, record := range sqsEvent.Records {
+		if rateLimited {
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+			continue
+		}
+
+		log := requestLog.With(common.LogMessageId, record.MessageId)
+		var request common.ActorEnrichmentRequest
+		err := json.Unmarshal([]byte(record.Body), &request)
+		if err != nil || request.Login == "" {
+			log.Warn("skipping invalid message", "body", record.Body, "error", err)
+			continue
+		}
+
+		err = enrichActor(ctx, request.Login)
+		if errors.Is(err, errRateLimited) {
+			log.Warn("github rate limit reserve reached, retrying the remaining logins later")
+			rateLimited = true
+		}
+		if err != nil {
+			log.Error("failed to enrich actor", "actor", request.Login, "error", err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+	return response, nil
+}
+
+func enrichActor(ctx context.Context, login string) error {
+	fresh, err := isProfileFresh(login)
+	if err != nil {
+		return err
+	}
+	if fresh {
+		return nil
+	}
+
+	user, response, err := client.Users.Get(ctx, login)
+	if response != nil && response.StatusCode == http.StatusNotFound {
+		// Deleted accounts are not retried until the profile TTL expires
+		return saveProfile(login, &github.User{})
+	}
+	var rateLimitErr *github.RateLimitError
+	var abuseRateLimitErr *github.AbuseRateLimitError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseRateLimitErr) {
+		return errRateLimited
+	}
+	if err != nil {
+		return err
+	}
+
+	err = saveProfile(login, user)
+	if err != nil {
+		return err
+	}
+	if response.Rate.Remaining < rateLimitReserve {
+		return errRateLimited
+	}
+	return nil
+}
+
+// isProfileFresh tells whether the actor's profile was fetched within the
+// profile TTL, first from the container cache then from the actor record
+func isProfileFresh(login string) (bool, error) {
+	if t, ok := fetchedAt[login]; ok && time.Since(t) < profileTTL {
+		return true, nil
+	}
+
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(actorTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Login": {S: aws.String(login)},
+		},
+		ProjectionExpression: aws.String("ProfileFetchedAt"),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	value, ok := result.Item["ProfileFetchedAt"]
+	if !ok {
+		return false, nil
+	}
+	seconds, err := strconv.ParseInt(aws.StringValue(value.N), 10, 64)
+	if err != nil {
+		return false, nil
+	}
+	t := time.Unix(seconds, 0)
+	fetchedAt[login] = t
+	return time.Since(t) < profileTTL, nil
+}
+
+func saveProfile(login string, user *github.User) error {
+	updateExpression := "SET ActorName = :name, Email = :email, Company = :company, #location = :location, Followers = :followers, AccountType = :accountType, ProfileFetchedAt = :fetchedAt"
+	expressionAttributeNames := map[string]*string{
+		"#location": aws.String("Location"),
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":name": {
+			S: aws.String(user.GetName()),
+		},
+		":email": {
+			S: aws.String(user.GetEmail()),
+		},
+		":company": {
+			S: aws.String(user.GetCompany()),
+		},
+		":location": {
+			S: aws.String(user.GetLocation()),
+		},
+		":followers": {
+			N: aws.String(strconv.Itoa(user.GetFollowers())),
+		},
+		":accountType": {
+			S: aws.String(user.GetType()),
+		},
+		":fetchedAt": {
+			N: aws.String(fmt.Sprintf("%d", time.Now().Unix())),
+		},
+	}
+
+	// GitHub knows which accounts are bots better than the consumer heuristics
+	if user.GetType() == "Bot" {
+		updateExpression += ", IsBot = :isBot, BotReason = :botReason"
+		expressionAttributeValues[":isBot"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+		expressionAttributeValues[":botReason"] = &dynamodb.AttributeValue{S: aws.String(common.BotReasonAccountType)}
+	}
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(actorTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Login": {S: aws.String(login)},
+		},
+		UpdateExpression:          aws.String(updateExpression),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		ReturnValues:              aws.String("NONE"),
+	}
+
+	_, err := db.UpdateItem(updateInput)
+	if err != nil {
+		return err
+	}
+	fetchedAt[login] = time.Now()
+	return nil
+}
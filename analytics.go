@@ -0,0 +1,176 @@
+package main
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/dynamodb"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/sns"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type EventAnalyticsArgs struct {
+	// Aggregate tables the scores and detections are computed from
+	Leaderboard  *dynamodb.Table
+	RepoActivity *dynamodb.Table
+
+	TableSettings      tableSettings
+	TrendingAggregator lambdaSettings
+	AnomalyDetector    lambdaSettings
+}
+
+// EventAnalytics scores the trending repos and detects bursts of events every
+// hour from the aggregates of the consumer
+type EventAnalytics struct {
+	pulumi.ResourceState
+	component
+
+	TrendingTable      *dynamodb.Table
+	AnomaliesTable     *dynamodb.Table
+	AlertsTopic        *sns.Topic
+	TrendingAggregator *lambda.Function
+	AnomalyDetector    *lambda.Function
+}
+
+func NewEventAnalytics(ctx *pulumi.Context, name string, args *EventAnalyticsArgs, opts ...pulumi.ResourceOption) (*EventAnalytics, error) {
+	c := &EventAnalytics{}
+	c.component = component{name: name, resource: c}
+	err := ctx.RegisterComponentResource("pointfive:pipeline:EventAnalytics", name, c, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Trending scores per window, sorted by the ScoreIndex
+	c.TrendingTable, err = dynamodb.NewTable(ctx, c.resourceName("TrendingTable"), args.TableSettings.apply(&dynamodb.TableArgs{
+		Attributes: dynamodb.TableAttributeArray{
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Window"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("RepoName"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Score"),
+				Type: pulumi.String("N"),
+			},
+		},
+		HashKey:  pulumi.String("Window"),
+		RangeKey: pulumi.String("RepoName"),
+		LocalSecondaryIndexes: dynamodb.TableLocalSecondaryIndexArray{
+			&dynamodb.TableLocalSecondaryIndexArgs{
+				Name:           pulumi.String("ScoreIndex"),
+				RangeKey:       pulumi.String("Score"),
+				ProjectionType: pulumi.String("ALL"),
+			},
+		},
+		Ttl: &dynamodb.TableTtlArgs{
+			AttributeName: pulumi.String("ExpiresAt"),
+			Enabled:       pulumi.Bool(true),
+		},
+		TableClass: pulumi.String("STANDARD"),
+	}), c.childOptions("TrendingTable")...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Bursts of events detected per repo and metric
+	c.AnomaliesTable, err = dynamodb.NewTable(ctx, c.resourceName("AnomaliesTable"), args.TableSettings.apply(&dynamodb.TableArgs{
+		Attributes: dynamodb.TableAttributeArray{
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("RepoName"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Detection"),
+				Type: pulumi.String("S"),
+			},
+		},
+		HashKey:  pulumi.String("RepoName"),
+		RangeKey: pulumi.String("Detection"),
+		Ttl: &dynamodb.TableTtlArgs{
+			AttributeName: pulumi.String("ExpiresAt"),
+			Enabled:       pulumi.Bool(true),
+		},
+		TableClass: pulumi.String("STANDARD"),
+	}), c.childOptions("AnomaliesTable")...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Subscribe to this topic to be notified of anomalies
+	c.AlertsTopic, err = sns.NewTopic(ctx, c.resourceName("alertsTopic"), &sns.TopicArgs{}, c.childOptions("alertsTopic")...)
+	if err != nil {
+		return nil, err
+	}
+
+	trendingAggregatorRole, err := c.newLambdaRole(ctx, "trendingAggregatorRole",
+		allowTables([]string{"Query"}, args.Leaderboard, args.RepoActivity),
+		allowTables([]string{"PutItem"}, c.TrendingTable),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c.TrendingAggregator, err = c.newFunction(ctx, "trendingAggregator", args.TrendingAggregator, &lambda.FunctionArgs{
+		Role: trendingAggregatorRole.Arn,
+		Environment: &lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap{
+				"LEADERBOARD_TABLE":   args.Leaderboard.Name,
+				"REPO_ACTIVITY_TABLE": args.RepoActivity.Name,
+				"TRENDING_TABLE":      c.TrendingTable.Name,
+			},
+		},
+	}, pulumi.DependsOn([]pulumi.Resource{args.Leaderboard, args.RepoActivity, c.TrendingTable}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Recompute trending scores once every complete hour
+	err = c.newSchedule(ctx, "everyHour", pulumi.String("cron(5 * * * ? *)"), c.TrendingAggregator, "trendingAggregator")
+	if err != nil {
+		return nil, err
+	}
+
+	anomalyDetectorRole, err := c.newLambdaRole(ctx, "anomalyDetectorRole",
+		allowTables([]string{"Query"}, args.Leaderboard, args.RepoActivity),
+		allowTables([]string{"GetItem", "PutItem"}, c.AnomaliesTable),
+		allowTopicPublish(c.AlertsTopic),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c.AnomalyDetector, err = c.newFunction(ctx, "anomalyDetector", args.AnomalyDetector, &lambda.FunctionArgs{
+		Role: anomalyDetectorRole.Arn,
+		Environment: &lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap{
+				"LEADERBOARD_TABLE":   args.Leaderboard.Name,
+				"REPO_ACTIVITY_TABLE": args.RepoActivity.Name,
+				"ANOMALIES_TABLE":     c.AnomaliesTable.Name,
+				"ALERTS_TOPIC_ARN":    c.AlertsTopic.Arn,
+			},
+		},
+	}, pulumi.DependsOn([]pulumi.Resource{args.Leaderboard, args.RepoActivity, c.AnomaliesTable, c.AlertsTopic}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the last complete hour for bursts of events
+	err = c.newSchedule(ctx, "everyHourAnomalies", pulumi.String("cron(10 * * * ? *)"), c.AnomalyDetector, "anomalyDetector")
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(c, pulumi.Map{
+		"trendingTable":      c.TrendingTable.Name,
+		"alertsTopic":        c.AlertsTopic.Arn,
+		"trendingAggregator": c.TrendingAggregator.Arn,
+		"anomalyDetector":    c.AnomalyDetector.Arn,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
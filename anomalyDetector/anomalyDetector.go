@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+var leaderboardTableName string
+var repoActivityTableName string
+var anomaliesTableName string
+var alertsTopicArn string
+
+var db *dynamodb.DynamoDB
+var metrics = common.NewMetrics()
+var logger = common.NewLogger()
+var snsClient *sns.SNS
+
+// Only the busiest repos of the checked hour are checked
+const maxCandidates = 500
+
+// Number of hours before the checked hour the baseline is computed from
+const baselineHours = 24
+
+const anomalyRetention = 30 * 24 * time.Hour
+
+// Metric of the total count of events of a repo, other metrics are event types
+const totalMetric = "Total"
+
+type Anomaly struct {
+	RepoName  string
+	Metric    string
+	Bucket    string
+	Value     float64
+	Baseline  float64
+	ZScore    float64
+	Detection string
+}
+
+func main() {
+	leaderboardTableName = os.Getenv("LEADERBOARD_TABLE")
+	if leaderboardTableName == "" {
+		logger.Error("LEADERBOARD_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("LEADERBOARD_TABLE is set", "value", leaderboardTableName)
+
+	repoActivityTableName = os.Getenv("REPO_ACTIVITY_TABLE")
+	if repoActivityTableName == "" {
+		logger.Error("REPO_ACTIVITY_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("REPO_ACTIVITY_TABLE is set", "value", repoActivityTableName)
+
+	anomaliesTableName = os.Getenv("ANOMALIES_TABLE")
+	if anomaliesTableName == "" {
+		logger.Error("ANOMALIES_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("ANOMALIES_TABLE is set", "value", anomaliesTableName)
+
+	alertsTopicArn = os.Getenv("ALERTS_TOPIC_ARN")
+	if alertsTopicArn == "" {
+		logger.Error("ALERTS_TOPIC_ARN environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("ALERTS_TOPIC_ARN is set", "value", alertsTopicArn)
+
+	sess := session.Must(session.NewSession())
+	db = dynamodb.New(sess)
+	metrics.TrackLatency(&db.Handlers, "DynamoDBLatency")
+	snsClient = sns.New(sess)
+
+	lambda.Start(handler)
+}
+
+// handler checks the last complete hour of the busiest repos against their
+// baseline of the day before
+func handler(ctx context.Context) error {
+	defer metrics.Flush()
+	log := common.RequestLogger(ctx, logger)
+	hour := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	candidates, err := getCandidates(hour)
+	if err != nil {
+		log.Error("failed to get anomaly candidates", "error", err)
+		return err
+	}
+
+	found := 0
+	for _, repoName := range candidates {
+		series, err := getActivitySeries(repoName, hour)
+		if err != nil {
+			log.Error("failed to get repo activity", "repo", repoName, "error", err)
+			return err
+		}
+		for metric, points := range series {
+			detection := Detect(points, defaultDetectorConfig)
+			if !detection.Anomaly {
+				continue
+			}
+			anomaly := Anomaly{
+				RepoName:  repoName,
+				Metric:    metric,
+				Bucket:    common.HourBucket(hour),
+				Value:     detection.Value,
+				Baseline:  detection.Baseline,
+				ZScore:    detection.ZScore,
+				Detection: common.HourBucket(hour) + "#" + metric,
+			}
+			// Anomalies already recorded by a previous run were already alerted
+			recorded, err := isAnomalyRecorded(anomaly)
+			if err != nil {
+				log.Error("failed to get anomaly", "detection", anomaly.Detection, "repo", repoName, "error", err)
+				return err
+			}
+			if recorded {
+				continue
+			}
+			// The anomaly is only recorded once alerted, so the retries of a
+			// failed run publish it again
+			err = publishAlert(anomaly)
+			if err != nil {
+				log.Error("failed to publish alert", "detection", anomaly.Detection, "repo", repoName, "error", err)
+				return err
+			}
+			err = saveAnomaly(anomaly)
+			if err != nil {
+				log.Error("failed to save anomaly", "detection", anomaly.Detection, "repo", repoName, "error", err)
+				return err
+			}
+			found++
+		}
+	}
+	log.Info("done detecting anomalies", "candidates", len(candidates), "anomalies", found)
+	return nil
+}
+
+func getCandidates(hour time.Time) ([]string, error) {
+	board, err := common.BoardKey(common.BoardRepos, common.WindowHour, hour)
+	if err != nil {
+		return nil, err
+	}
+	result, err := db.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(leaderboardTableName),
+		IndexName:              aws.String(common.LeaderboardByCountIndex),
+		KeyConditionExpression: aws.String("Board = :board"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":board": {S: aws.String(board)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(maxCandidates),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []string{}
+	for _, i := range result.Items {
+		candidates = append(candidates, *i["Member"].S)
+	}
+	return candidates, nil
+}
+
+// getActivitySeries returns the hourly event counts of the repo, in total and
+// per event type, for the baseline hours followed by the checked hour.
+// Hours without events are zeros.
+func getActivitySeries(repoName string, hour time.Time) (map[string][]float64, error) {
+	start := hour.Add(-baselineHours * time.Hour)
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(repoActivityTableName),
+		KeyConditionExpression: aws.String("RepoName = :repoName AND Bucket BETWEEN :from AND :to"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":repoName": {S: aws.String(repoName)},
+			":from":     {S: aws.String(common.HourBucket(start))},
+			":to":       {S: aws.String(common.HourBucket(hour))},
+		},
+	}
+
+	series := map[string][]float64{}
+	points := baselineHours + 1
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			bucket, err := time.Parse("2006010215", aws.StringValue(i["Bucket"].S))
+			if err != nil {
+				continue
+			}
+			index := int(bucket.Sub(start) / time.Hour)
+			if index < 0 || index >= points {
+				continue
+			}
+			for name, value := range i {
+				if name != totalMetric && !strings.HasPrefix(name, "Type_") {
+					continue
+				}
+				metric := strings.TrimPrefix(name, "Type_")
+				count, err := strconv.ParseFloat(aws.StringValue(value.N), 64)
+				if err != nil {
+					continue
+				}
+				if _, ok := series[metric]; !ok {
+					series[metric] = make([]float64, points)
+				}
+				series[metric][index] = count
+			}
+		}
+		return true
+	})
+	return series, err
+}
+
+// isAnomalyRecorded returns whether the anomaly was already recorded
+func isAnomalyRecorded(anomaly Anomaly) (bool, error) {
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(anomaliesTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"RepoName":  {S: aws.String(anomaly.RepoName)},
+			"Detection": {S: aws.String(anomaly.Detection)},
+		},
+		ProjectionExpression: aws.String("Detection"),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(result.Item) > 0, nil
+}
+
+// saveAnomaly records the anomaly, an anomaly already recorded is kept
+func saveAnomaly(anomaly Anomaly) error {
+	putInput := &dynamodb.PutItemInput{
+		TableName: aws.String(anomaliesTableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"RepoName":   {S: aws.String(anomaly.RepoName)},
+			"Detection":  {S: aws.String(anomaly.Detection)},
+			"Metric":     {S: aws.String(anomaly.Metric)},
+			"Bucket":     {S: aws.String(anomaly.Bucket)},
+			"Value":      {N: aws.String(strconv.FormatFloat(anomaly.Value, 'f', -1, 64))},
+			"Baseline":   {N: aws.String(strconv.FormatFloat(anomaly.Baseline, 'f', 4, 64))},
+			"ZScore":     {N: aws.String(strconv.FormatFloat(anomaly.ZScore, 'f', 4, 64))},
+			"DetectedAt": {N: aws.String(fmt.Sprintf("%d", time.Now().Unix()))},
+			"ExpiresAt":  {N: aws.String(fmt.Sprintf("%d", time.Now().Add(anomalyRetention).Unix()))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(Detection)"),
+	}
+
+	_, err := db.PutItem(putInput)
+	if err != nil {
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func publishAlert(anomaly Anomaly) error {
+	message, err := json.Marshal(anomaly)
+	if err != nil {
+		return err
+	}
+
+	// SNS subjects are limited to 100 characters
+	subject := fmt.Sprintf("Burst of %s on %s", anomaly.Metric, anomaly.RepoName)
+	if len(subject) > 100 {
+		subject = subject[:100]
+	}
+
+	_, err = snsClient.Publish(&sns.PublishInput{
+		TopicArn: aws.String(alertsTopicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(string(message)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String("anomaly")},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
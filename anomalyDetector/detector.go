@@ -0,0 +1,81 @@
+package main
+
+import "math"
+
+// DetectorConfig tunes when the last point of a series is an anomaly
+type DetectorConfig struct {
+	// Alpha is the EWMA smoothing factor of the baseline, higher values
+	// follow recent points more closely
+	Alpha float64
+	// Threshold is the z-score above which the last point is an anomaly
+	Threshold float64
+	// MinValue ignores bursts too small to matter, whatever their z-score
+	MinValue float64
+	// MinStdDev keeps perfectly flat baselines from flagging any change
+	MinStdDev float64
+	// MinHistory is the number of baseline points with activity required,
+	// series first active inside the baseline have no baseline to compare to
+	MinHistory int
+}
+
+var defaultDetectorConfig = DetectorConfig{
+	Alpha:      0.3,
+	Threshold:  4,
+	MinValue:   10,
+	MinStdDev:  1,
+	MinHistory: 3,
+}
+
+// Detection is the outcome of checking the last point of a series against
+// the EWMA baseline of the points before it
+type Detection struct {
+	Value    float64
+	Baseline float64
+	StdDev   float64
+	ZScore   float64
+	Anomaly  bool
+}
+
+// ewma returns the exponentially weighted moving mean and standard deviation
+// of the series, computed incrementally so recent points weigh the most
+func ewma(series []float64, alpha float64) (float64, float64) {
+	if len(series) == 0 {
+		return 0, 0
+	}
+	mean := series[0]
+	variance := 0.0
+	for _, x := range series[1:] {
+		diff := x - mean
+		increment := alpha * diff
+		mean += increment
+		variance = (1 - alpha) * (variance + diff*increment)
+	}
+	return mean, math.Sqrt(variance)
+}
+
+// Detect checks whether the last point of an evenly spaced series is an
+// upward burst compared to the baseline of the points before it. Drops in
+// activity are not anomalies, and neither are bursts of series with less
+// than MinHistory active points before them.
+func Detect(series []float64, config DetectorConfig) Detection {
+	if len(series) < 2 {
+		return Detection{}
+	}
+	value := series[len(series)-1]
+	baseline, stdDev := ewma(series[:len(series)-1], config.Alpha)
+
+	detection := Detection{
+		Value:    value,
+		Baseline: baseline,
+		StdDev:   stdDev,
+		ZScore:   (value - baseline) / math.Max(stdDev, config.MinStdDev),
+	}
+	active := 0
+	for _, x := range series[:len(series)-1] {
+		if x > 0 {
+			active++
+		}
+	}
+	detection.Anomaly = value >= config.MinValue && detection.ZScore >= config.Threshold && active >= config.MinHistory
+	return detection
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEwma(t *testing.T) {
+	tests := []struct {
+		name         string
+		series       []float64
+		alpha        float64
+		mean, stdDev float64
+	}{
+		{"empty", nil, 0.3, 0, 0},
+		{"single point", []float64{7}, 0.3, 7, 0},
+		{"flat", []float64{5, 5, 5, 5, 5}, 0.3, 5, 0},
+		{"step", []float64{0, 10}, 0.5, 5, 5},
+		{"recent points weigh the most", []float64{0, 0, 0, 10, 10, 10}, 0.5, 8.75, 3.3072},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mean, stdDev := ewma(test.series, test.alpha)
+			if math.Abs(mean-test.mean) > 1e-4 || math.Abs(stdDev-test.stdDev) > 1e-4 {
+				t.Errorf("ewma = %v, %v, want %v, %v", mean, stdDev, test.mean, test.stdDev)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	noisy := []float64{20, 24, 18, 22, 21, 19, 23, 20, 22, 18, 21, 24}
+	with := func(series []float64, last float64) []float64 {
+		return append(append([]float64{}, series...), last)
+	}
+
+	tests := []struct {
+		name    string
+		series  []float64
+		anomaly bool
+		// Range of the expected z-score
+		minZScore, maxZScore float64
+	}{
+		{"no history", nil, false, 0, 0},
+		{"short history", []float64{50}, false, 0, 0},
+		{"flat", with([]float64{10, 10, 10, 10}, 10), false, 0, 0},
+		{"spike on a flat baseline", with([]float64{10, 10, 10, 10}, 30), true, 20, 20},
+		{"small step on a flat baseline", with([]float64{10, 10, 10, 10}, 12), false, 2, 2},
+		{"zero variance below the minimum value", with([]float64{0, 0, 0, 0}, 5), false, 5, 5},
+		// Repos first active within the baseline have no history to compare to
+		{"burst without history", with([]float64{0, 0, 0, 0}, 50), false, 50, 50},
+		{"burst after too short a history", with([]float64{0, 0, 0, 2, 2}, 50), false, 40, math.Inf(1)},
+		{"burst after the minimum history", with([]float64{0, 0, 2, 2, 2}, 50), true, 40, math.Inf(1)},
+		{"burst on a sparse baseline", with([]float64{3, 0, 0, 0, 0, 4, 0, 0, 0, 0, 2, 0, 0, 0}, 60), true, 4, math.Inf(1)},
+		{"noise", with(noisy, 25), false, 0, 4},
+		{"spike on noise", with(noisy, 80), true, 4, math.Inf(1)},
+		{"drop", with(noisy, 0), false, math.Inf(-1), 0},
+		{"drop to nothing on a flat baseline", with([]float64{40, 40, 40, 40}, 0), false, -40, -40},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detection := Detect(test.series, defaultDetectorConfig)
+			if detection.Anomaly != test.anomaly {
+				t.Errorf("anomaly = %v, want %v (%+v)", detection.Anomaly, test.anomaly, detection)
+			}
+			if detection.ZScore < test.minZScore || detection.ZScore > test.maxZScore {
+				t.Errorf("z-score = %v, want between %v and %v", detection.ZScore, test.minZScore, test.maxZScore)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/ahmads/common"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/athena"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/glue"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/kinesis"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/s3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type EventArchiveArgs struct {
+	// Let pulumi destroy delete the bucket along with the archived events
+	ForceDestroy bool
+}
+
+// EventArchive is the data lake of the raw events, written by the consumer
+// through Firehose as Parquet partitioned by date and event type, queried
+// with Athena
+type EventArchive struct {
+	pulumi.ResourceState
+	component
+
+	Bucket    *s3.Bucket
+	Database  *glue.CatalogDatabase
+	Table     *glue.CatalogTable
+	Stream    *kinesis.FirehoseDeliveryStream
+	Workgroup *athena.Workgroup
+}
+
+func NewEventArchive(ctx *pulumi.Context, name string, args *EventArchiveArgs, opts ...pulumi.ResourceOption) (*EventArchive, error) {
+	c := &EventArchive{}
+	c.component = component{name: name, resource: c}
+	err := ctx.RegisterComponentResource("pointfive:pipeline:EventArchive", name, c, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Bucket, err = s3.NewBucket(ctx, c.resourceName("eventsArchive"), &s3.BucketArgs{
+		ForceDestroy: pulumi.Bool(args.ForceDestroy),
+	}, c.childOptions("eventsArchive")...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Glue and Athena names only allow lower case letters, digits and underscores
+	archiveDatabaseName := "github_archive_" + strings.ToLower(strings.ReplaceAll(ctx.Stack(), "-", "_"))
+	if name != defaultPipeline {
+		archiveDatabaseName += "_" + strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+	}
+	c.Database, err = glue.NewCatalogDatabase(ctx, c.resourceName("eventsArchiveDatabase"), &glue.CatalogDatabaseArgs{
+		Name: pulumi.String(archiveDatabaseName),
+	}, c.childOptions("eventsArchiveDatabase")...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partitions are projected from the date and the known event types, so
+	// new ones are queryable without registering them
+	c.Table, err = glue.NewCatalogTable(ctx, c.resourceName("eventsArchiveTable"), &glue.CatalogTableArgs{
+		Name:         pulumi.String("events"),
+		DatabaseName: c.Database.Name,
+		TableType:    pulumi.String("EXTERNAL_TABLE"),
+		Parameters: pulumi.StringMap{
+			"classification":            pulumi.String("parquet"),
+			"projection.enabled":        pulumi.String("true"),
+			"projection.dt.type":        pulumi.String("date"),
+			"projection.dt.format":      pulumi.String("yyyy-MM-dd"),
+			"projection.dt.range":       pulumi.String("2011-02-12,NOW"),
+			"projection.type.type":      pulumi.String("enum"),
+			"projection.type.values":    pulumi.String(common.ArchivePartitionTypes()),
+			"storage.location.template": pulumi.Sprintf("s3://%s/events/dt=${dt}/type=${type}/", c.Bucket.Bucket),
+		},
+		PartitionKeys: glue.CatalogTablePartitionKeyArray{
+			&glue.CatalogTablePartitionKeyArgs{Name: pulumi.String("dt"), Type: pulumi.String("string")},
+			&glue.CatalogTablePartitionKeyArgs{Name: pulumi.String("type"), Type: pulumi.String("string")},
+		},
+		StorageDescriptor: &glue.CatalogTableStorageDescriptorArgs{
+			Location:     pulumi.Sprintf("s3://%s/events/", c.Bucket.Bucket),
+			InputFormat:  pulumi.String("org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat"),
+			OutputFormat: pulumi.String("org.apache.hadoop.hive.ql.io.parquet.MapredParquetOutputFormat"),
+			SerDeInfo: &glue.CatalogTableStorageDescriptorSerDeInfoArgs{
+				SerializationLibrary: pulumi.String("org.apache.hadoop.hive.ql.io.parquet.serde.ParquetHiveSerDe"),
+			},
+			Columns: glue.CatalogTableStorageDescriptorColumnArray{
+				&glue.CatalogTableStorageDescriptorColumnArgs{Name: pulumi.String("actor_login"), Type: pulumi.String("string")},
+				&glue.CatalogTableStorageDescriptorColumnArgs{Name: pulumi.String("actor_name"), Type: pulumi.String("string")},
+				&glue.CatalogTableStorageDescriptorColumnArgs{Name: pulumi.String("actor_email"), Type: pulumi.String("string")},
+				&glue.CatalogTableStorageDescriptorColumnArgs{Name: pulumi.String("repo_name"), Type: pulumi.String("string")},
+				&glue.CatalogTableStorageDescriptorColumnArgs{Name: pulumi.String("repo_url"), Type: pulumi.String("string")},
+				&glue.CatalogTableStorageDescriptorColumnArgs{Name: pulumi.String("repo_id"), Type: pulumi.String("bigint")},
+				&glue.CatalogTableStorageDescriptorColumnArgs{Name: pulumi.String("event_type"), Type: pulumi.String("string")},
+				&glue.CatalogTableStorageDescriptorColumnArgs{Name: pulumi.String("created_at"), Type: pulumi.String("timestamp")},
+				&glue.CatalogTableStorageDescriptorColumnArgs{Name: pulumi.String("is_bot"), Type: pulumi.String("boolean")},
+				&glue.CatalogTableStorageDescriptorColumnArgs{Name: pulumi.String("backfill"), Type: pulumi.String("boolean")},
+			},
+		},
+	}, c.childOptions("eventsArchiveTable")...)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveFirehoseRole, err := newServiceRole(ctx, c.resourceName("eventsArchiveFirehoseRole"), "firehose.amazonaws.com", []policyStatement{
+		{
+			Actions: []string{
+				"s3:AbortMultipartUpload",
+				"s3:GetBucketLocation",
+				"s3:GetObject",
+				"s3:ListBucket",
+				"s3:ListBucketMultipartUploads",
+				"s3:PutObject",
+			},
+			Resources: pulumi.StringArray{c.Bucket.Arn, pulumi.Sprintf("%s/*", c.Bucket.Arn)},
+		},
+		{
+			Actions:   []string{"glue:GetTable", "glue:GetTableVersion", "glue:GetTableVersions"},
+			Resources: pulumi.StringArray{pulumi.String("*")},
+		},
+	}, c.childOptions("eventsArchiveFirehoseRole")...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Stream, err = kinesis.NewFirehoseDeliveryStream(ctx, c.resourceName("eventsArchiveStream"), &kinesis.FirehoseDeliveryStreamArgs{
+		Destination: pulumi.String("extended_s3"),
+		ExtendedS3Configuration: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationArgs{
+			RoleArn:   archiveFirehoseRole.Arn,
+			BucketArn: c.Bucket.Arn,
+			// Parquet conversion requires buffers of at least 64MB
+			BufferSize:        pulumi.Int(64),
+			BufferInterval:    pulumi.Int(300),
+			Prefix:            pulumi.String("events/dt=!{partitionKeyFromQuery:dt}/type=!{partitionKeyFromQuery:type}/"),
+			ErrorOutputPrefix: pulumi.String("errors/!{firehose:error-output-type}/!{timestamp:yyyy-MM-dd}/"),
+			DynamicPartitioningConfiguration: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationDynamicPartitioningConfigurationArgs{
+				Enabled: pulumi.Bool(true),
+			},
+			ProcessingConfiguration: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationProcessingConfigurationArgs{
+				Enabled: pulumi.Bool(true),
+				Processors: kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationProcessingConfigurationProcessorArray{
+					&kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationProcessingConfigurationProcessorArgs{
+						Type: pulumi.String("MetadataExtraction"),
+						Parameters: kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationProcessingConfigurationProcessorParameterArray{
+							&kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationProcessingConfigurationProcessorParameterArgs{
+								ParameterName:  pulumi.String("JsonParsingEngine"),
+								ParameterValue: pulumi.String("JQ-1.6"),
+							},
+							&kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationProcessingConfigurationProcessorParameterArgs{
+								ParameterName:  pulumi.String("MetadataExtractionQuery"),
+								ParameterValue: pulumi.String("{dt: .dt, type: .type}"),
+							},
+						},
+					},
+				},
+			},
+			DataFormatConversionConfiguration: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationDataFormatConversionConfigurationArgs{
+				InputFormatConfiguration: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationDataFormatConversionConfigurationInputFormatConfigurationArgs{
+					Deserializer: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationDataFormatConversionConfigurationInputFormatConfigurationDeserializerArgs{
+						OpenXJsonSerDe: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationDataFormatConversionConfigurationInputFormatConfigurationDeserializerOpenXJsonSerDeArgs{},
+					},
+				},
+				OutputFormatConfiguration: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationDataFormatConversionConfigurationOutputFormatConfigurationArgs{
+					Serializer: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationDataFormatConversionConfigurationOutputFormatConfigurationSerializerArgs{
+						ParquetSerDe: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationDataFormatConversionConfigurationOutputFormatConfigurationSerializerParquetSerDeArgs{
+							Compression: pulumi.String("SNAPPY"),
+						},
+					},
+				},
+				SchemaConfiguration: &kinesis.FirehoseDeliveryStreamExtendedS3ConfigurationDataFormatConversionConfigurationSchemaConfigurationArgs{
+					RoleArn:      archiveFirehoseRole.Arn,
+					DatabaseName: c.Database.Name,
+					TableName:    c.Table.Name,
+				},
+			},
+		},
+	}, c.childOptions("eventsArchiveStream")...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Athena writes the results of the ad-hoc queries next to the archive
+	c.Workgroup, err = athena.NewWorkgroup(ctx, c.resourceName("eventsArchiveWorkgroup"), &athena.WorkgroupArgs{
+		ForceDestroy: pulumi.Bool(true),
+		Configuration: &athena.WorkgroupConfigurationArgs{
+			ResultConfiguration: &athena.WorkgroupConfigurationResultConfigurationArgs{
+				OutputLocation: pulumi.Sprintf("s3://%s/athena-results/", c.Bucket.Bucket),
+			},
+		},
+	}, c.childOptions("eventsArchiveWorkgroup")...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(c, pulumi.Map{
+		"bucket":    c.Bucket.Bucket,
+		"database":  c.Database.Name,
+		"stream":    c.Stream.Name,
+		"workgroup": c.Workgroup.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,366 @@
+// backfill seeds the tables with history by replaying GH Archive hourly files
+// (https://www.gharchive.org) through the consumer queue.
+//
+//	go run . -source ./gharchive -from 2023-07-01-0 -to 2023-09-30-23
+//	go run . -source s3://bucket/gharchive -from 2023-07-01-0 -to 2023-09-30-23 -rate 200
+//
+// The last sent position is saved to the state file after every batch, running
+// the same command again resumes from it.
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/go-github/v55/github"
+)
+
+// GH Archive names its files after the hour they cover, without zero padding
+// the hour, e.g. 2023-10-01-5.json.gz
+const hourLayout = "2006-01-02-15"
+
+// Events sent to the queue between two saves of the state file, a single
+// SQS batch so the saved position only covers acknowledged events
+const batchSize = 10
+
+// The consumer updates every leaderboard board once per event, a DynamoDB
+// partition takes at most 1000 writes a second
+const defaultRate = 500
+
+type options struct {
+	Source    string
+	From      time.Time
+	To        time.Time
+	QueueUrl  string
+	Rate      int
+	StateFile string
+}
+
+// state is the position of the backfill, Line events of the Hour file were
+// already sent
+type state struct {
+	Hour string
+	Line int
+}
+
+func main() {
+	source := flag.String("source", "", "directory or s3://bucket/prefix holding the GH Archive .json.gz files")
+	from := flag.String("from", "", "first hour to backfill, as in the GH Archive file names (2023-10-01-0)")
+	to := flag.String("to", "", "last hour to backfill, defaults to the first one")
+	queueUrl := flag.String("queue-url", os.Getenv("GITHUB_CONSUMER_SQS_URL"), "consumer SQS queue url, defaults to GITHUB_CONSUMER_SQS_URL")
+	rate := flag.Int("rate", defaultRate, "maximum events sent per second")
+	stateFile := flag.String("state", "backfill.state", "file saving the position to resume from")
+	flag.Parse()
+
+	opts, err := parseOptions(*source, *from, *to, *queueUrl, *rate, *stateFile)
+	if err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	sink := common.NewSQSSink(sqs.New(sess), opts.QueueUrl)
+	archive := newArchive(sess, opts.Source)
+
+	err = backfill(opts, archive, sink)
+	if err != nil {
+		fmt.Println("backfill failed, run again to resume:", err)
+		os.Exit(1)
+	}
+	fmt.Println("backfill done")
+}
+
+func parseOptions(source, from, to, queueUrl string, rate int, stateFile string) (options, error) {
+	if source == "" {
+		return options{}, errors.New("-source is required")
+	}
+	if queueUrl == "" {
+		return options{}, errors.New("-queue-url or GITHUB_CONSUMER_SQS_URL is required")
+	}
+	if rate <= 0 {
+		return options{}, errors.New("-rate must be positive")
+	}
+
+	fromHour, err := parseHour(from)
+	if err != nil {
+		return options{}, fmt.Errorf("invalid -from: %w", err)
+	}
+	toHour := fromHour
+	if to != "" {
+		toHour, err = parseHour(to)
+		if err != nil {
+			return options{}, fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+	if toHour.Before(fromHour) {
+		return options{}, errors.New("-to is before -from")
+	}
+
+	return options{
+		Source:    source,
+		From:      fromHour,
+		To:        toHour,
+		QueueUrl:  queueUrl,
+		Rate:      rate,
+		StateFile: stateFile,
+	}, nil
+}
+
+// parseHour accepts the hour with or without zero padding
+func parseHour(value string) (time.Time, error) {
+	index := strings.LastIndex(value, "-")
+	if index < 0 {
+		return time.Time{}, fmt.Errorf("%q is not formatted as 2023-10-01-5", value)
+	}
+	hour := value[index+1:]
+	if len(hour) == 1 {
+		value = value[:index+1] + "0" + hour
+	}
+	return time.Parse(hourLayout, value)
+}
+
+func hourName(t time.Time) string {
+	return fmt.Sprintf("%s-%d", t.Format("2006-01-02"), t.Hour())
+}
+
+func backfill(opts options, archive archive, sink common.EventSink) error {
+	resume, err := loadState(opts.StateFile)
+	if err != nil {
+		return err
+	}
+
+	start := opts.From
+	if resume.Hour != "" {
+		resumeHour, err := parseHour(resume.Hour)
+		if err != nil {
+			return fmt.Errorf("invalid state file %s: %w", opts.StateFile, err)
+		}
+		if resumeHour.After(start) {
+			start = resumeHour
+		}
+		fmt.Println("resuming from", resume.Hour, "line", resume.Line)
+	}
+
+	throttle := newThrottle(opts.Rate)
+	for hour := start; !hour.After(opts.To); hour = hour.Add(time.Hour) {
+		name := hourName(hour)
+		skip := 0
+		if name == resume.Hour {
+			skip = resume.Line
+		}
+
+		sent, err := backfillHour(archive, name, skip, sink, throttle, func(line int) error {
+			return saveState(opts.StateFile, state{Hour: name, Line: line})
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Println("backfilled", name, sent, "events")
+
+		next := hourName(hour.Add(time.Hour))
+		err = saveState(opts.StateFile, state{Hour: next})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillHour sends the events of one hourly file from line skip on,
+// calling checkpoint with the number of lines done after every batch
+func backfillHour(archive archive, name string, skip int, sink common.EventSink, throttle *throttle, checkpoint func(line int) error) (int, error) {
+	reader, err := archive.Open(name + ".json.gz")
+	if errors.Is(err, errMissingFile) {
+		// GH Archive has a few missing hours
+		fmt.Println("no file for", name, "skipping")
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	// Some payloads, large pushes for instance, exceed the default line size
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	// The events of an hour are traced by the name of the hour
+	ctx := common.WithCorrelationId(context.Background(), "backfill-"+name)
+	line := 0
+	sent := 0
+	var batch []common.Github_event
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		throttle.Wait(len(batch))
+		if err := sink.Send(ctx, batch); err != nil {
+			return err
+		}
+		sent += len(batch)
+		batch = batch[:0]
+		return checkpoint(line)
+	}
+
+	for scanner.Scan() {
+		line++
+		if line <= skip {
+			continue
+		}
+
+		var archived github.Event
+		if err := json.Unmarshal(scanner.Bytes(), &archived); err != nil {
+			fmt.Println("skipping invalid event", name, "line", line, err)
+			continue
+		}
+		batch = append(batch, toGithubEvent(&archived))
+
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return sent, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return sent, err
+	}
+	return sent, flush()
+}
+
+// toGithubEvent maps an archived event the way the fetcher maps the events of
+// the public events API, GH Archive records them in the same format
+func toGithubEvent(event *github.Event) common.Github_event {
+	return common.Github_event{
+		ActorLogin: event.Actor.GetLogin(),
+		ActorEmail: event.Actor.GetEmail(),
+		ActorName:  event.Actor.GetName(),
+		RepoUrl:    event.Repo.GetURL(),
+		RepoName:   event.Repo.GetName(),
+		RepoId:     event.Repo.GetID(),
+		EventType:  event.GetType(),
+		CreatedAt:  event.GetCreatedAt().Time,
+		Backfill:   true,
+		EventId:    event.GetID(),
+	}
+}
+
+func loadState(path string) (state, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state{}, nil
+	}
+	if err != nil {
+		return state{}, err
+	}
+	var s state
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+// saveState replaces the state file atomically so an interrupted run never
+// leaves it half written
+func saveState(path string, s state) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// throttle spreads the sends so they never exceed rate events per second
+type throttle struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newThrottle(rate int) *throttle {
+	return &throttle{interval: time.Second / time.Duration(rate)}
+}
+
+func (t *throttle) Wait(events int) {
+	now := time.Now()
+	if t.next.After(now) {
+		time.Sleep(t.next.Sub(now))
+	} else {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(events) * t.interval)
+}
+
+var errMissingFile = errors.New("missing archive file")
+
+// archive opens GH Archive files by name from a local directory or S3
+type archive interface {
+	Open(name string) (io.ReadCloser, error)
+}
+
+func newArchive(sess *session.Session, source string) archive {
+	if strings.HasPrefix(source, "s3://") {
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(source, "s3://"), "/")
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		return &s3Archive{client: s3.New(sess), bucket: bucket, prefix: prefix}
+	}
+	return dirArchive(source)
+}
+
+type dirArchive string
+
+func (d dirArchive) Open(name string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(string(d), name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errMissingFile
+	}
+	return file, err
+}
+
+type s3Archive struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+func (a *s3Archive) Open(name string) (io.ReadCloser, error) {
+	result, err := a.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(a.prefix + name),
+	})
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, errMissingFile
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result.Body, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lambdaModules are the directories of the Go modules built into each lambda
+var lambdaModules = map[string]string{
+	"githubEventsFetcher":    "githubEventsFetcher",
+	"githubWebhook":          "githubWebhook",
+	"githubEventsConsumer":   "githubEventsConsumer",
+	"trendingAggregator":     "trendingAggregator",
+	"anomalyDetector":        "anomalyDetector",
+	"repoEnricher":           "repoEnricher",
+	"actorEnricher":          "actorEnricher",
+	"resolverLambdaFunction": "API",
+}
+
+// sharedModules are built into every lambda through their replace directives
+var sharedModules = []string{"common"}
+
+// buildDir keeps the zips of the last build of each module and architecture
+const buildDir = "tmp/build"
+
+// buildFlags of every lambda, the lambda.norpc tag leaves out the RPC mode of
+// the go1.x runtime and the paths are trimmed so the binaries only depend on
+// their sources
+var buildFlags = []string{"-trimpath", "-buildvcs=false", "-tags", "lambda.norpc", "-ldflags", "-s -w"}
+
+// zipTime is the modification time of the zipped binaries, a fixed time keeps
+// the zips of identical binaries identical
+var zipTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type lambdaBuild struct {
+	done chan struct{}
+	path string
+	err  error
+}
+
+var (
+	buildsMu sync.Mutex
+	builds   = map[string]*lambdaBuild{}
+)
+
+// lambdaBuilder starts the build of a module, tests replace it to skip the
+// builds
+var lambdaBuilder = startBuild
+
+// startBuild builds the zip of the bootstrap binary of the module in the
+// background, once per module and GOARCH
+func startBuild(module, goarch string) *lambdaBuild {
+	buildsMu.Lock()
+	defer buildsMu.Unlock()
+
+	key := module + "-" + goarch
+	if b, ok := builds[key]; ok {
+		return b
+	}
+	b := &lambdaBuild{done: make(chan struct{})}
+	builds[key] = b
+	go func() {
+		defer close(b.done)
+		b.path, b.err = buildLambda(module, goarch)
+	}()
+	return b
+}
+
+// wait returns the path of the zip once built
+func (b *lambdaBuild) wait() (string, error) {
+	<-b.done
+	return b.path, b.err
+}
+
+// buildLambda zips the bootstrap binary of the module for linux/goarch. The
+// zip is named after the hash of the sources, so unchanged modules are not
+// built again
+func buildLambda(module, goarch string) (string, error) {
+	hash, err := sourceHash(module, goarch)
+	if err != nil {
+		return "", fmt.Errorf("hash %s: %w", module, err)
+	}
+	prefix := filepath.Join(buildDir, module+"-"+goarch+"-")
+	path := prefix + hash[:16] + ".zip"
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	err = os.MkdirAll(buildDir, 0o755)
+	if err != nil {
+		return "", err
+	}
+	binary, err := os.MkdirTemp(buildDir, module)
+	if err != nil {
+		return "", err
+	}
+	// The build runs in the module directory
+	binary, err = filepath.Abs(binary)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(binary)
+
+	args := append([]string{"build"}, buildFlags...)
+	args = append(args, "-o", filepath.Join(binary, "bootstrap"), ".")
+	cmd := exec.Command("go", args...)
+	cmd.Dir = module
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+goarch, "CGO_ENABLED=0")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("build %s: %w\n%s", module, err, output)
+	}
+
+	err = writeZip(filepath.Join(binary, "bootstrap"), path)
+	if err != nil {
+		return "", fmt.Errorf("zip %s: %w", module, err)
+	}
+
+	// Only the last build of the module is kept
+	previous, _ := filepath.Glob(prefix + "*.zip")
+	for _, zip := range previous {
+		if zip != path {
+			os.Remove(zip)
+		}
+	}
+	return path, nil
+}
+
+// sourceHash hashes the Go sources of the module and of the shared modules,
+// with the toolchain, flags and architecture they are built with
+func sourceHash(module, goarch string) (string, error) {
+	version, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", strings.TrimSpace(string(version)), goarch, strings.Join(buildFlags, " "))
+	for _, dir := range append([]string{module}, sharedModules...) {
+		var files []string
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := entry.Name()
+			if entry.IsDir() || !(strings.HasSuffix(name, ".go") || name == "go.mod" || name == "go.sum") {
+				return nil
+			}
+			files = append(files, path)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+
+		sort.Strings(files)
+		for _, path := range files {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s %d\n", filepath.ToSlash(path), len(content))
+			h.Write(content)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeZip zips the binary as an executable bootstrap, with a fixed time so
+// identical binaries give identical zips
+func writeZip(binary, path string) error {
+	in, err := os.Open(binary)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := zip.NewWriter(out)
+	header := &zip.FileHeader{Name: "bootstrap", Method: zip.Deflate, Modified: zipTime}
+	header.SetMode(0o755)
+	entry, err := w.CreateHeader(header)
+	if err == nil {
+		_, err = io.Copy(entry, in)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
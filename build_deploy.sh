@@ -1,17 +1,4 @@
-
-rm -rf ./tmp
-
-cd githubEventsFetcher
-GOOS=linux go build -o ../tmp/githubEventsFetcher githubEventsFetcher.go
-cd ..
-zip -j ./tmp/githubEventsFetcher.zip ./tmp/githubEventsFetcher
-
-cd githubEventsConsumer && GOOS=linux go build -o ../tmp/githubEventsConsumer githubEventsConsumer.go
-cd ..
-zip -j ./tmp/githubEventsConsumer.zip ./tmp/githubEventsConsumer
-
-cd API && GOOS=linux go build -o ../tmp/api api.go
-cd ..
-zip -j ./tmp/api.zip ./tmp/api
-
-pulumi up --yes
\ No newline at end of file
+# The Pulumi program builds the lambdas itself, see build.go
+# The policy pack is the analyzer plugin the go runtime of policy/PulumiPolicy.yaml starts
+(cd policy && go build -o bin/pulumi-analyzer-policy-go .) || exit 1
+PATH="$PWD/policy/bin:$PATH" pulumi up --yes --policy-pack policy
@@ -0,0 +1,50 @@
+package common
+
+import "strings"
+
+// ArchiveEventTypes are the event types the raw event archive is partitioned
+// by, the public events API types. Events of other types, only sent by
+// webhooks, share the ArchiveOtherType partition
+var ArchiveEventTypes = []string{
+	"CommitCommentEvent",
+	"CreateEvent",
+	"DeleteEvent",
+	"ForkEvent",
+	"GollumEvent",
+	"IssueCommentEvent",
+	"IssuesEvent",
+	"MemberEvent",
+	"PublicEvent",
+	"PullRequestEvent",
+	"PullRequestReviewEvent",
+	"PullRequestReviewCommentEvent",
+	"PullRequestReviewThreadEvent",
+	"PushEvent",
+	"ReleaseEvent",
+	"SponsorshipEvent",
+	"WatchEvent",
+}
+
+const ArchiveOtherType = "Other"
+
+// ArchiveDateFormat formats the date partition of the archive
+const ArchiveDateFormat = "2006-01-02"
+
+// ArchiveTimestampFormat is the Hive timestamp format the Parquet conversion
+// parses timestamps with
+const ArchiveTimestampFormat = "2006-01-02 15:04:05"
+
+// ArchivePartitionType returns the type partition of an event type
+func ArchivePartitionType(eventType string) string {
+	for _, archived := range ArchiveEventTypes {
+		if archived == eventType {
+			return eventType
+		}
+	}
+	return ArchiveOtherType
+}
+
+// ArchivePartitionTypes lists every type partition, for partition projection
+func ArchivePartitionTypes() string {
+	return strings.Join(append(append([]string{}, ArchiveEventTypes...), ArchiveOtherType), ",")
+}
@@ -0,0 +1,64 @@
+package common
+
+import "strings"
+
+// Bot classification reasons stored on the actor record
+const (
+	BotReasonLoginSuffix  = "login-suffix"
+	BotReasonKnownBot     = "known-bot"
+	BotReasonActivityRate = "activity-rate"
+	BotReasonAccountType  = "account-type"
+)
+
+// Accounts acting as bots without using GitHub's [bot] login suffix
+var knownBots = map[string]bool{
+	"dependabot":                      true,
+	"dependabot-preview":              true,
+	"renovate":                        true,
+	"renovate-bot":                    true,
+	"github-actions":                  true,
+	"greenkeeperio-bot":               true,
+	"snyk-bot":                        true,
+	"imgbot":                          true,
+	"codecov-io":                      true,
+	"allcontributors":                 true,
+	"pre-commit-ci":                   true,
+	"mergify":                         true,
+	"netlify":                         true,
+	"vercel":                          true,
+	"k8s-ci-robot":                    true,
+	"openshift-ci-robot":              true,
+	"pull":                            true,
+	"stale":                           true,
+	"whitesource-bolt":                true,
+	"sonarcloud":                      true,
+	"coveralls":                       true,
+	"deepsource-autofix":              true,
+	"restyled-io":                     true,
+	"azure-pipelines":                 true,
+	"google-cla":                      true,
+	"microsoft-github-policy-service": true,
+}
+
+var botSuffixes = []string{"[bot]", "-bot", "_bot", "-robot"}
+
+// ClassifyLogin tells whether the login belongs to a bot from its name alone,
+// returning the classification reason
+func ClassifyLogin(login string) (bool, string) {
+	login = strings.ToLower(login)
+	for _, suffix := range botSuffixes {
+		if strings.HasSuffix(login, suffix) {
+			return true, BotReasonLoginSuffix
+		}
+	}
+	if knownBots[login] {
+		return true, BotReasonKnownBot
+	}
+	return false, ""
+}
+
+// HumanOnly returns the key of the variant of a leaderboard or trending
+// window which only counts events of human actors
+func HumanOnly(key string) string {
+	return "HUMAN_" + key
+}
@@ -1,5 +1,7 @@
 package common
 
+import "time"
+
 var Version string = "1.0"
 
 type Github_event struct {
@@ -10,4 +12,17 @@ type Github_event struct {
 	RepoName   string
 	RepoId     int64
 	EventType  string
+	CreatedAt  time.Time
+	// Backfill marks historical events replayed from GH Archive, they update
+	// the tables but trigger no alerts
+	Backfill bool `json:",omitempty"`
+	// EventId identifies the event in the logs, the GitHub event ID or the
+	// delivery ID of webhook events
+	EventId string `json:",omitempty"`
+}
+
+// ActorEnrichmentRequest asks the actorEnricher to fetch the GitHub profile
+// of a newly seen actor
+type ActorEnrichmentRequest struct {
+	Login string
 }
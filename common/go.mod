@@ -1,3 +1,10 @@
 module github.com/ahmads/common
 
 go 1.21.1
+
+require (
+	github.com/aws/aws-lambda-go v1.41.0
+	github.com/aws/aws-sdk-go v1.45.11
+)
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
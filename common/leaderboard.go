@@ -0,0 +1,53 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// Leaderboard dimensions maintained by the consumer
+const (
+	BoardRepos      = "REPO"
+	BoardActors     = "ACTOR"
+	BoardEventTypes = "TYPE"
+	BoardLanguages  = "LANG"
+)
+
+// Leaderboard time windows, HOUR and DAY are the current UTC hour and day
+const (
+	WindowHour = "HOUR"
+	WindowDay  = "DAY"
+	WindowAll  = "ALL"
+)
+
+var Windows = []string{WindowHour, WindowDay, WindowAll}
+
+// BoardKey returns the partition key of the leaderboard of the given dimension
+// for the window containing t, i.e. REPO#HOUR#2023100114
+func BoardKey(dimension string, window string, t time.Time) (string, error) {
+	t = t.UTC()
+	switch window {
+	case WindowHour:
+		return fmt.Sprintf("%s#%s#%s", dimension, window, HourBucket(t)), nil
+	case WindowDay:
+		return fmt.Sprintf("%s#%s#%s", dimension, window, t.Format("20060102")), nil
+	case WindowAll:
+		return fmt.Sprintf("%s#%s", dimension, window), nil
+	default:
+		return "", fmt.Errorf("unknown window %q", window)
+	}
+}
+
+// BoardExpiry returns when the leaderboard of the window containing t can be
+// dropped, zero time means never
+func BoardExpiry(window string, t time.Time) time.Time {
+	t = t.UTC()
+	switch window {
+	case WindowHour:
+		return t.Truncate(time.Hour).Add(48 * time.Hour)
+	case WindowDay:
+		return t.Truncate(24 * time.Hour).Add(30 * 24 * time.Hour)
+	default:
+		return time.Time{}
+	}
+}
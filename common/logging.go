@@ -0,0 +1,59 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Keys of the log fields shared by the lambdas, a Logs Insights query on one
+// of them follows an event across the logs of every function
+const (
+	LogRequestId     = "requestId"
+	LogEventId       = "eventId"
+	LogMessageId     = "messageId"
+	LogCorrelationId = "correlationId"
+)
+
+// CorrelationIdAttribute is the SQS message attribute carrying the
+// correlation ID of the events from their producer to the consumer
+const CorrelationIdAttribute = "CorrelationId"
+
+// NewLogger returns a logger writing JSON lines to stdout at the LOG_LEVEL
+// level (debug, info, warn or error), info by default
+func NewLogger() *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(os.Getenv("LOG_LEVEL")))); err != nil {
+		level = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+type correlationIdKey struct{}
+
+// WithCorrelationId returns a context carrying the correlation ID, which the
+// SQSSink sends along the events
+func WithCorrelationId(ctx context.Context, correlationId string) context.Context {
+	return context.WithValue(ctx, correlationIdKey{}, correlationId)
+}
+
+// CorrelationId returns the correlation ID of the context, empty if none
+func CorrelationId(ctx context.Context) string {
+	correlationId, _ := ctx.Value(correlationIdKey{}).(string)
+	return correlationId
+}
+
+// RequestLogger adds the request ID of the Lambda invocation of the context
+// and its correlation ID, when there are, to the logger
+func RequestLogger(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		logger = logger.With(LogRequestId, lc.AwsRequestID)
+	}
+	if correlationId := CorrelationId(ctx); correlationId != "" {
+		logger = logger.With(LogCorrelationId, correlationId)
+	}
+	return logger
+}
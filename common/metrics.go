@@ -0,0 +1,191 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// MetricsNamespace is the CloudWatch namespace of the metrics of every lambda
+const MetricsNamespace = "PointFive"
+
+// Units of the metrics, as CloudWatch names them
+const (
+	UnitCount        = "Count"
+	UnitMilliseconds = "Milliseconds"
+)
+
+// A metric document holds at most 100 metrics and 100 values per metric
+const maxMetricValues = 100
+
+// Dimension is a dimension of a metric besides the FunctionName
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// Metrics collects the metrics of an invocation and prints them on Flush as
+// CloudWatch Embedded Metric Format documents, which CloudWatch Logs turns
+// into metrics without calls to the CloudWatch API. Every metric has the
+// FunctionName dimension, metrics recorded with more dimensions are also
+// published without them. The methods of a nil *Metrics do nothing
+type Metrics struct {
+	mu           sync.Mutex
+	out          io.Writer
+	functionName string
+	documents    map[string]*metricDocument
+}
+
+type metricDocument struct {
+	dimensions []Dimension
+	values     map[string][]float64
+	units      map[string]string
+}
+
+// NewMetrics collects metrics published under the name of the lambda
+func NewMetrics() *Metrics {
+	return &Metrics{
+		out:          os.Stdout,
+		functionName: os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
+		documents:    map[string]*metricDocument{},
+	}
+}
+
+// Count adds value to the count metric
+func (m *Metrics) Count(name string, value float64, dimensions ...Dimension) {
+	m.add(name, UnitCount, value, dimensions)
+}
+
+// Milliseconds records a duration, each value is kept so CloudWatch computes
+// the percentiles
+func (m *Metrics) Milliseconds(name string, duration time.Duration, dimensions ...Dimension) {
+	m.add(name, UnitMilliseconds, float64(duration)/float64(time.Millisecond), dimensions)
+}
+
+func (m *Metrics) add(name, unit string, value float64, dimensions []Dimension) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var key strings.Builder
+	for _, dimension := range dimensions {
+		fmt.Fprintf(&key, "%s=%s;", dimension.Name, dimension.Value)
+	}
+	document, ok := m.documents[key.String()]
+	if !ok {
+		document = &metricDocument{dimensions: dimensions, values: map[string][]float64{}, units: map[string]string{}}
+		m.documents[key.String()] = document
+	}
+
+	values := document.values[name]
+	if unit == UnitCount && len(values) > 0 {
+		values[0] += value
+	} else {
+		document.values[name] = append(values, value)
+	}
+	document.units[name] = unit
+}
+
+// TrackLatency records the latency of the requests of an AWS client, retries
+// included, as the metric by API operation
+func (m *Metrics) TrackLatency(handlers *request.Handlers, name string) {
+	handlers.Complete.PushBack(func(r *request.Request) {
+		m.Milliseconds(name, time.Since(r.Time), Dimension{"Operation", r.Operation.Name})
+	})
+}
+
+// Flush prints the metrics collected since the last Flush, lambdas defer it in
+// their handler
+func (m *Metrics) Flush() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.documents))
+	for key := range m.documents {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	timestamp := time.Now().UnixMilli()
+	for _, key := range keys {
+		document := m.documents[key]
+		for len(document.values) > 0 {
+			err := m.write(document, timestamp)
+			if err != nil {
+				fmt.Println("failed to write metrics:", err)
+				break
+			}
+		}
+	}
+	m.documents = map[string]*metricDocument{}
+}
+
+// write prints one document with up to maxMetricValues values of each of up
+// to maxMetricValues metrics, and drops the printed values
+func (m *Metrics) write(document *metricDocument, timestamp int64) error {
+	names := make([]string, 0, len(document.values))
+	for name := range document.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > maxMetricValues {
+		names = names[:maxMetricValues]
+	}
+
+	dimensionNames := []string{"FunctionName"}
+	fields := map[string]interface{}{"FunctionName": m.functionName}
+	for _, dimension := range document.dimensions {
+		dimensionNames = append(dimensionNames, dimension.Name)
+		fields[dimension.Name] = dimension.Value
+	}
+	dimensionSets := [][]string{dimensionNames}
+	if len(dimensionNames) > 1 {
+		dimensionSets = append(dimensionSets, []string{"FunctionName"})
+	}
+
+	var definitions []map[string]string
+	for _, name := range names {
+		values := document.values[name]
+		count := len(values)
+		if count > maxMetricValues {
+			count = maxMetricValues
+		}
+		if count == 1 {
+			fields[name] = values[0]
+		} else {
+			fields[name] = values[:count]
+		}
+		if count == len(values) {
+			delete(document.values, name)
+		} else {
+			document.values[name] = values[count:]
+		}
+		definitions = append(definitions, map[string]string{"Name": name, "Unit": document.units[name]})
+	}
+	fields["_aws"] = map[string]interface{}{
+		"Timestamp": timestamp,
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  MetricsNamespace,
+			"Dimensions": dimensionSets,
+			"Metrics":    definitions,
+		}},
+	}
+
+	line, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(m.out, string(line))
+	return err
+}
@@ -0,0 +1,422 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"unicode"
+)
+
+// Alert rule delivery target types
+const (
+	TargetSNS     = "SNS"
+	TargetSQS     = "SQS"
+	TargetWebhook = "WEBHOOK"
+)
+
+var TargetTypes = []string{TargetSNS, TargetSQS, TargetWebhook}
+
+// RuleTargets are the SNS topic ARNs and SQS queue URLs alert rules may
+// deliver to, the consumer is not allowed to publish or send to others
+type RuleTargets map[string]bool
+
+// ParseRuleTargets parses the comma separated targets of RULE_TARGETS
+func ParseRuleTargets(value string) RuleTargets {
+	targets := RuleTargets{}
+	for _, target := range strings.Split(value, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets[target] = true
+		}
+	}
+	return targets
+}
+
+// Special purpose IPv4 networks net.IP does not classify: this network,
+// carrier-grade NAT, IETF protocol assignments, benchmarking and reserved
+var reservedNetworks = parseNetworks("0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// IsPublicAddress tells whether a webhook may be delivered to the address,
+// loopback, private, link-local (including the instance metadata endpoints
+// 169.254.169.254 and fd00:ec2::254) and other non routable addresses are
+// off limits
+func IsPublicAddress(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateWebhookTarget checks that the webhook target is an https URL of a
+// public host. Hostnames are resolved on delivery, where WebhookDialControl
+// checks the addresses they resolve to.
+func ValidateWebhookTarget(target string) error {
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Scheme != "https" || parsed.Hostname() == "" || parsed.User != nil {
+		return errors.New("WEBHOOK target must be an https URL")
+	}
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if ip := net.ParseIP(host); ip != nil {
+		if !IsPublicAddress(ip) {
+			return fmt.Errorf("WEBHOOK target %s is not a public address", host)
+		}
+		return nil
+	}
+	if !strings.Contains(host, ".") || host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return fmt.Errorf("WEBHOOK target %s is not a public host", host)
+	}
+	return nil
+}
+
+// WebhookDialControl refuses connections to addresses which are not public,
+// set as the Control of the net.Dialer delivering webhooks so hostnames
+// resolving to internal addresses are refused too
+func WebhookDialControl(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !IsPublicAddress(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+// Rule is a parsed alert rule expression such as
+//
+//	type == "ReleaseEvent" && repo == "org/repo"
+//	actor == "octocat" && type in ["PushEvent", "CreateEvent"]
+//	repo ~ "hashicorp/*" && !(type == "WatchEvent")
+//
+// Comparisons take a field (type, repo, repoUrl, actor) on the left and a
+// double quoted string on the right. == and != compare exactly, ~ matches a
+// glob pattern and in matches any string of a list. Comparisons combine with
+// &&, || and !, grouped with parentheses.
+type Rule struct {
+	Expression string
+	root       ruleNode
+}
+
+// Limits of the rule names and expressions, the name is the subject of the
+// SNS notifications which takes at most 100 printable ASCII characters
+const (
+	MaxRuleNameLength       = 80
+	MaxRuleExpressionLength = 1024
+)
+
+// ValidateRuleName accepts names of printable ASCII characters, at most
+// MaxRuleNameLength long
+func ValidateRuleName(name string) error {
+	if name == "" {
+		return errors.New("rule name is required")
+	}
+	if len(name) > MaxRuleNameLength {
+		return fmt.Errorf("rule name is longer than %d characters", MaxRuleNameLength)
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < ' ' || name[i] > '~' {
+			return fmt.Errorf("rule name has a character other than printable ASCII at offset %d", i)
+		}
+	}
+	return nil
+}
+
+var ruleFields = map[string]func(event Github_event) string{
+	"type":    func(event Github_event) string { return event.EventType },
+	"repo":    func(event Github_event) string { return event.RepoName },
+	"repoUrl": func(event Github_event) string { return event.RepoUrl },
+	"actor":   func(event Github_event) string { return event.ActorLogin },
+}
+
+// ParseRule parses a rule expression, see Rule for the syntax
+func ParseRule(expression string) (*Rule, error) {
+	if len(expression) > MaxRuleExpressionLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", MaxRuleExpressionLength)
+	}
+	tokens, err := tokenizeRule(expression)
+	if err != nil {
+		return nil, err
+	}
+	parser := &ruleParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.peek().kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected %s at offset %d", parser.peek(), parser.peek().offset)
+	}
+	return &Rule{Expression: expression, root: root}, nil
+}
+
+// Match tells whether the event satisfies the rule
+func (r *Rule) Match(event Github_event) bool {
+	return r.root.match(event)
+}
+
+type ruleNode interface {
+	match(event Github_event) bool
+}
+
+type andNode struct{ left, right ruleNode }
+type orNode struct{ left, right ruleNode }
+type notNode struct{ operand ruleNode }
+
+type comparisonNode struct {
+	field    string
+	operator string
+	values   []string
+}
+
+func (n andNode) match(event Github_event) bool {
+	return n.left.match(event) && n.right.match(event)
+}
+
+func (n orNode) match(event Github_event) bool {
+	return n.left.match(event) || n.right.match(event)
+}
+
+func (n notNode) match(event Github_event) bool {
+	return !n.operand.match(event)
+}
+
+func (n comparisonNode) match(event Github_event) bool {
+	value := ruleFields[n.field](event)
+	switch n.operator {
+	case "==":
+		return value == n.values[0]
+	case "!=":
+		return value != n.values[0]
+	case "~":
+		matched, _ := path.Match(n.values[0], value)
+		return matched
+	case "in":
+		for _, v := range n.values {
+			if value == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const (
+	tokenEnd = iota
+	tokenIdent
+	tokenString
+	tokenOperator
+)
+
+type ruleToken struct {
+	kind   int
+	value  string
+	offset int
+}
+
+func (t ruleToken) String() string {
+	switch t.kind {
+	case tokenEnd:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.value)
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+func tokenizeRule(expression string) ([]ruleToken, error) {
+	tokens := []ruleToken{}
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			var value strings.Builder
+			start := i
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at offset %d", start)
+			}
+			i++
+			tokens = append(tokens, ruleToken{kind: tokenString, value: value.String(), offset: start})
+		case unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, ruleToken{kind: tokenIdent, value: string(runes[start:i]), offset: start})
+		default:
+			operator := ""
+			for _, op := range []string{"==", "!=", "&&", "||", "!", "~", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					operator = op
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", r, i)
+			}
+			tokens = append(tokens, ruleToken{kind: tokenOperator, value: operator, offset: i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, ruleToken{kind: tokenEnd, offset: len(runes)}), nil
+}
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+func (p *ruleParser) peek() ruleToken {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() ruleToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEnd {
+		p.pos++
+	}
+	return token
+}
+
+func (p *ruleParser) accept(operator string) bool {
+	token := p.peek()
+	if token.kind == tokenOperator && token.value == operator {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) expect(kind int, what string) (ruleToken, error) {
+	token := p.next()
+	if token.kind != kind {
+		return token, fmt.Errorf("expected %s at offset %d, got %s", what, token.offset, token)
+	}
+	return token, nil
+}
+
+func (p *ruleParser) parseOr() (ruleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (ruleNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseUnary() (ruleNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	if p.accept("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("expected \")\" at offset %d, got %s", p.peek().offset, p.peek())
+		}
+		return node, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *ruleParser) parseComparison() (ruleNode, error) {
+	field, err := p.expect(tokenIdent, "a field")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ruleFields[field.value]; !ok {
+		return nil, fmt.Errorf("unknown field %q at offset %d", field.value, field.offset)
+	}
+
+	operator := p.next()
+	switch {
+	case operator.kind == tokenOperator && (operator.value == "==" || operator.value == "!=" || operator.value == "~"):
+		value, err := p.expect(tokenString, "a string")
+		if err != nil {
+			return nil, err
+		}
+		if operator.value == "~" {
+			if _, err := path.Match(value.value, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q at offset %d", value.value, value.offset)
+			}
+		}
+		return comparisonNode{field: field.value, operator: operator.value, values: []string{value.value}}, nil
+	case operator.kind == tokenIdent && operator.value == "in":
+		if !p.accept("[") {
+			return nil, fmt.Errorf("expected \"[\" at offset %d, got %s", p.peek().offset, p.peek())
+		}
+		values := []string{}
+		for {
+			value, err := p.expect(tokenString, "a string")
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value.value)
+			if p.accept("]") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, fmt.Errorf("expected \",\" or \"]\" at offset %d, got %s", p.peek().offset, p.peek())
+			}
+		}
+		return comparisonNode{field: field.value, operator: "in", values: values}, nil
+	default:
+		return nil, fmt.Errorf("expected an operator at offset %d, got %s", operator.offset, operator)
+	}
+}
@@ -0,0 +1,20 @@
+package common
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// ReadSecret reads the SecureString SSM parameter holding a secret, the stack
+// passes the lambdas the names of the parameters rather than the secrets
+func ReadSecret(sess *session.Session, parameterName string) (string, error) {
+	result, err := ssm.New(sess).GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(parameterName),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(result.Parameter.Value), nil
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// EventSink receives the events produced by the fetcher, the webhook and the
+// backfill, all of them flow through the consumer queue
+type EventSink interface {
+	// Send sends the events along the correlation ID of the context
+	Send(ctx context.Context, events []Github_event) error
+}
+
+// SQS accepts at most 10 messages in a batch
+const sqsBatchSize = 10
+
+// SQSSink sends events to the consumer queue in batches
+type SQSSink struct {
+	Client   *sqs.SQS
+	QueueUrl string
+	// Counts the EventsSent and SendFailures, optional
+	Metrics *Metrics
+}
+
+func NewSQSSink(client *sqs.SQS, queueUrl string) *SQSSink {
+	return &SQSSink{Client: client, QueueUrl: queueUrl}
+}
+
+func (s *SQSSink) Send(ctx context.Context, events []Github_event) error {
+	for start := 0; start < len(events); start += sqsBatchSize {
+		end := start + sqsBatchSize
+		if end > len(events) {
+			end = len(events)
+		}
+		if err := s.sendBatch(ctx, events[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQSSink) sendBatch(ctx context.Context, events []Github_event) error {
+	var attributes map[string]*sqs.MessageAttributeValue
+	if correlationId := CorrelationId(ctx); correlationId != "" {
+		attributes = map[string]*sqs.MessageAttributeValue{
+			CorrelationIdAttribute: {DataType: aws.String("String"), StringValue: aws.String(correlationId)},
+		}
+	}
+
+	var entries []*sqs.SendMessageBatchRequestEntry
+	for index, event := range events {
+		jsonMessage, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &sqs.SendMessageBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(index)),
+			MessageBody:       aws.String(string(jsonMessage)),
+			MessageAttributes: attributes,
+		})
+	}
+
+	result, err := s.Client.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(s.QueueUrl),
+		Entries:  entries,
+	})
+	if err != nil {
+		s.Metrics.Count("SendFailures", float64(len(entries)))
+		return err
+	}
+	s.Metrics.Count("EventsSent", float64(len(entries)-len(result.Failed)))
+	s.Metrics.Count("SendFailures", float64(len(result.Failed)))
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("failed to send %d of %d events: %s", len(result.Failed), len(entries), aws.StringValue(result.Failed[0].Message))
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package common
+
+// Every item of the actors, repos and events count tables carries a constant
+// Kind attribute, the partition key of the table's ordered indexes, so the
+// API can Query them in order instead of scanning the table
+const (
+	KindActor = "ACTOR"
+	KindRepo  = "REPO"
+	KindEvent = "EVENT"
+)
+
+// Ordered global secondary indexes of the actors, repos and events count tables
+const (
+	ActorsByLastActionIndex  = "ByLastAction"
+	ReposByLastActivityIndex = "ByLastActivity"
+	EventsByCountIndex       = "ByCount"
+	EventsByHumanCountIndex  = "ByHumanCount"
+)
+
+// Name ordered global secondary indexes of the actors, repos and events count tables
+const (
+	ActorsByLoginIndex = "ByLogin"
+	ReposByNameIndex   = "ByName"
+	EventsByTypeIndex  = "ByType"
+)
+
+// LeaderboardByCountIndex is the global secondary index of the leaderboard
+// table sorting the members of each Board by their Count
+const LeaderboardByCountIndex = "CountIndex"
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// TrendWindow compares the activity of a repo in its most recent hours to a
+// longer baseline right before it
+type TrendWindow struct {
+	Name     string
+	Recent   time.Duration
+	Baseline time.Duration
+}
+
+var TrendWindows = []TrendWindow{
+	{Name: WindowHour, Recent: time.Hour, Baseline: 24 * time.Hour},
+	{Name: WindowDay, Recent: 24 * time.Hour, Baseline: 7 * 24 * time.Hour},
+}
+
+// RepoActivityRetention is how long hourly repo activity buckets are kept,
+// enough to cover the longest trend window
+const RepoActivityRetention = 9 * 24 * time.Hour
+
+func GetTrendWindow(name string) (TrendWindow, error) {
+	for _, window := range TrendWindows {
+		if window.Name == name {
+			return window, nil
+		}
+	}
+	return TrendWindow{}, fmt.Errorf("unknown trend window %q", name)
+}
+
+// HourBucket returns the sortable UTC hour bucket containing t, i.e. 2023100114
+func HourBucket(t time.Time) string {
+	return t.UTC().Format("2006010215")
+}
+
+// EventWeight is how much an event counts towards a repo trending score,
+// stars and forks signal interest much more than regular activity
+func EventWeight(eventType string) int {
+	switch eventType {
+	case "WatchEvent":
+		return 3
+	case "ForkEvent":
+		return 5
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/sqs"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// defaultPipeline is the pipeline every stack deploys. Its resources keep the
+// names they had before the stack was split into components
+const defaultPipeline = "github"
+
+// component is embedded in the ComponentResources of a pipeline, next to
+// their ResourceState, it names and parents their children
+type component struct {
+	name     string
+	resource pulumi.Resource
+}
+
+// resourceName names a child after the pipeline, the default pipeline keeps
+// the names of its resources
+func (c *component) resourceName(base string) string {
+	if c.name == defaultPipeline {
+		return base
+	}
+	return c.name + "-" + base
+}
+
+// childOptions parents a child to the component. In the default pipeline the
+// child is aliased to the resource it replaces, created without parent, so
+// existing stacks update it in place
+func (c *component) childOptions(base string, opts ...pulumi.ResourceOption) []pulumi.ResourceOption {
+	options := []pulumi.ResourceOption{pulumi.Parent(c.resource)}
+	if c.name == defaultPipeline {
+		options = append(options, pulumi.Aliases([]pulumi.Alias{{Name: pulumi.String(base), NoParent: pulumi.Bool(true)}}))
+	}
+	return append(options, opts...)
+}
+
+// renamedFrom aliases a child of the default pipeline to the name it had
+// before being renamed
+func (c *component) renamedFrom(old string) []pulumi.ResourceOption {
+	if c.name != defaultPipeline {
+		return nil
+	}
+	return []pulumi.ResourceOption{pulumi.Aliases([]pulumi.Alias{{Name: pulumi.String(old), NoParent: pulumi.Bool(true)}})}
+}
+
+// newLambdaRole creates the execution role of a lambda of the component
+func (c *component) newLambdaRole(ctx *pulumi.Context, base string, statements ...policyStatement) (*iam.Role, error) {
+	return newLambdaRole(ctx, c.resourceName(base), statements, c.childOptions(base)...)
+}
+
+// newFunction creates a lambda of the component sized by its settings, with
+// a log group keeping its logs for the configured retention and LOG_LEVEL set
+// to its log level. The code is the bootstrap binary of its module, built for
+// the custom runtime
+func (c *component) newFunction(ctx *pulumi.Context, base string, settings lambdaSettings, args *lambda.FunctionArgs, opts ...pulumi.ResourceOption) (*lambda.Function, error) {
+	code, err := lambdaBuilder(lambdaModules[base], lambdaArchitectures[settings.Architecture]).wait()
+	if err != nil {
+		return nil, err
+	}
+	args.Code = pulumi.NewFileArchive(code)
+	args.Runtime = pulumi.String(settings.Runtime)
+	args.Architectures = pulumi.StringArray{pulumi.String(settings.Architecture)}
+	args.Handler = pulumi.String("bootstrap")
+	args.MemorySize = pulumi.Int(settings.Memory)
+	args.Timeout = pulumi.Int(settings.Timeout)
+	args.ReservedConcurrentExecutions = pulumi.Int(settings.ReservedConcurrency)
+	variables := pulumi.StringMap{}
+	if environment, ok := args.Environment.(*lambda.FunctionEnvironmentArgs); ok {
+		if set, ok := environment.Variables.(pulumi.StringMap); ok {
+			for name, value := range set {
+				variables[name] = value
+			}
+		}
+	}
+	variables["LOG_LEVEL"] = pulumi.String(settings.LogLevel)
+	args.Environment = &lambda.FunctionEnvironmentArgs{Variables: variables}
+	function, err := lambda.NewFunction(ctx, c.resourceName(base), args, c.childOptions(base, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	logGroupName := pulumi.Sprintf("/aws/lambda/%s", function.Name)
+	logGroupOptions := c.childOptions(base + "Logs")
+	if settings.ImportLogGroup {
+		// The group Lambda created is adopted whatever its retention, the
+		// configured one applies once importLogGroups is unset
+		logGroupOptions = append(logGroupOptions,
+			pulumi.Import(logGroupName.ApplyT(func(name string) pulumi.ID { return pulumi.ID(name) }).(pulumi.IDOutput)),
+			pulumi.IgnoreChanges([]string{"retentionInDays"}))
+	}
+	_, err = cloudwatch.NewLogGroup(ctx, c.resourceName(base+"Logs"), &cloudwatch.LogGroupArgs{
+		Name:            logGroupName,
+		RetentionInDays: pulumi.Int(settings.LogRetentionDays),
+	}, logGroupOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return function, nil
+}
+
+// newSecret stores a secret in a SecureString SSM parameter of the component,
+// the lambdas get the name of the parameter and read the secret at startup
+// so it stays out of their configuration
+func (c *component) newSecret(ctx *pulumi.Context, base string, value pulumi.StringInput) (*ssm.Parameter, error) {
+	return ssm.NewParameter(ctx, c.resourceName(base), &ssm.ParameterArgs{
+		Type:  pulumi.String("SecureString"),
+		Value: value,
+	}, c.childOptions(base)...)
+}
+
+// deadLetterRetention keeps the messages of the dead-letter queues for the
+// longest SQS allows, 14 days
+const deadLetterRetention = 1209600
+
+// newQueue creates a queue of the component with its dead-letter queue,
+// messages received maxReceiveCount times without being deleted are moved
+// to the dead-letter queue
+func (c *component) newQueue(ctx *pulumi.Context, base string, maxReceiveCount int, args *sqs.QueueArgs) (*sqs.Queue, *sqs.Queue, error) {
+	deadLetters, err := sqs.NewQueue(ctx, c.resourceName(base+"DeadLetters"), &sqs.QueueArgs{
+		MessageRetentionSeconds: pulumi.Int(deadLetterRetention),
+	}, c.childOptions(base+"DeadLetters")...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args.RedrivePolicy = deadLetters.Arn.ApplyT(func(arn string) string {
+		return fmt.Sprintf(`{"deadLetterTargetArn":%q,"maxReceiveCount":%d}`, arn, maxReceiveCount)
+	}).(pulumi.StringOutput)
+	queue, err := sqs.NewQueue(ctx, c.resourceName(base), args, c.childOptions(base)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return queue, deadLetters, nil
+}
+
+// newSchedule triggers the lambda on the EventBridge schedule expression, the
+// options apply to the rule and its target
+func (c *component) newSchedule(ctx *pulumi.Context, ruleName string, expression pulumi.StringInput, function *lambda.Function, functionName string, opts ...pulumi.ResourceOption) error {
+	rule, err := cloudwatch.NewEventRule(ctx, c.resourceName(ruleName), &cloudwatch.EventRuleArgs{
+		ScheduleExpression: expression,
+	}, c.childOptions(ruleName, opts...)...)
+	if err != nil {
+		return err
+	}
+
+	_, err = cloudwatch.NewEventTarget(ctx, c.resourceName(ruleName), &cloudwatch.EventTargetArgs{
+		Rule:     rule.Name,
+		TargetId: pulumi.String(functionName),
+		Arn:      function.Arn,
+	}, c.childOptions(ruleName, append(opts, pulumi.DependsOn([]pulumi.Resource{function, rule}))...)...)
+	if err != nil {
+		return err
+	}
+
+	permissionName := "allowTrigger" + strings.ToUpper(functionName[:1]) + functionName[1:] + "Lambda"
+	_, err = lambda.NewPermission(ctx, c.resourceName(permissionName), &lambda.PermissionArgs{
+		Action:    pulumi.String("lambda:InvokeFunction"),
+		Function:  function.Name,
+		Principal: pulumi.String("events.amazonaws.com"),
+		SourceArn: rule.Arn,
+	}, c.childOptions(permissionName, pulumi.DependsOn([]pulumi.Resource{function, rule}))...)
+	return err
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/dynamodb"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// lambdaSettings size a lambda, set per lambda with the lambdas config object
+type lambdaSettings struct {
+	Memory  int `json:"memory"`
+	Timeout int `json:"timeout"`
+	// -1 leaves the concurrency of the lambda unreserved
+	ReservedConcurrency int `json:"reservedConcurrency"`
+	LogRetentionDays    int `json:"logRetentionDays"`
+	// Lowest level of the logs of the lambda, its LOG_LEVEL
+	LogLevel string `json:"logLevel"`
+	// Set for every lambda by lambdaRuntime and lambdaArchitecture
+	Runtime      string `json:"-"`
+	Architecture string `json:"-"`
+	// Adopt the log group Lambda created, set for every lambda by
+	// importLogGroups
+	ImportLogGroup bool `json:"-"`
+}
+
+// defaultLambdas are the settings of the lambdas without config, before the
+// lambdaMemory and logRetentionDays defaults are applied
+var defaultLambdas = map[string]lambdaSettings{
+	"githubEventsFetcher":    {Timeout: 3, ReservedConcurrency: -1},
+	"githubWebhook":          {Timeout: 10, ReservedConcurrency: -1},
+	"githubEventsConsumer":   {Timeout: 30, ReservedConcurrency: -1},
+	"trendingAggregator":     {Timeout: 300, ReservedConcurrency: -1},
+	"anomalyDetector":        {Timeout: 300, ReservedConcurrency: -1},
+	"repoEnricher":           {Timeout: 600, ReservedConcurrency: -1},
+	"actorEnricher":          {Timeout: 300, ReservedConcurrency: 1},
+	"resolverLambdaFunction": {Timeout: 500, ReservedConcurrency: -1},
+}
+
+// tableSettings are the capacity settings of every table of the pipeline,
+// every table also has point-in-time recovery and KMS encryption
+type tableSettings struct {
+	BillingMode string
+	// Capacity units of the tables and their global indexes, PROVISIONED only
+	ReadCapacity  int
+	WriteCapacity int
+}
+
+const (
+	payPerRequest = "PAY_PER_REQUEST"
+	provisioned   = "PROVISIONED"
+)
+
+// apply sets the capacity settings on the table and its global indexes, and
+// enables point-in-time recovery and encryption with the AWS managed key
+func (s tableSettings) apply(args *dynamodb.TableArgs) *dynamodb.TableArgs {
+	args.PointInTimeRecovery = &dynamodb.TablePointInTimeRecoveryArgs{Enabled: pulumi.Bool(true)}
+	args.ServerSideEncryption = &dynamodb.TableServerSideEncryptionArgs{Enabled: pulumi.Bool(true)}
+	args.BillingMode = pulumi.String(s.BillingMode)
+	if s.BillingMode != provisioned {
+		return args
+	}
+	args.ReadCapacity = pulumi.Int(s.ReadCapacity)
+	args.WriteCapacity = pulumi.Int(s.WriteCapacity)
+	if indexes, ok := args.GlobalSecondaryIndexes.(dynamodb.TableGlobalSecondaryIndexArray); ok {
+		for _, index := range indexes {
+			if index, ok := index.(*dynamodb.TableGlobalSecondaryIndexArgs); ok {
+				index.ReadCapacity = pulumi.Int(s.ReadCapacity)
+				index.WriteCapacity = pulumi.Int(s.WriteCapacity)
+			}
+		}
+	}
+	return args
+}
+
+// Custom runtimes running the bootstrap binaries, provided.al2 is kept for
+// providers not knowing provided.al2023 yet
+var lambdaRuntimes = []string{"provided.al2023", "provided.al2"}
+
+// Architectures of the lambdas, by the GOARCH they are built with
+var lambdaArchitectures = map[string]string{"arm64": "arm64", "x86_64": "amd64"}
+
+// Levels of the lambda loggers, see common.NewLogger
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// CloudWatch Logs only accepts these retention periods
+var logRetentionDays = []int{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
+var (
+	scheduleExpression = regexp.MustCompile(`^(rate|cron)\(.+\)$`)
+	awsRegion          = regexp.MustCompile(`^[a-z]{2}(-gov)?-[a-z]+-\d$`)
+	topicArn           = regexp.MustCompile(`^arn:aws:sns:[a-z0-9-]+:\d{12}:[A-Za-z0-9_-]+(\.fifo)?$`)
+	queueUrl           = regexp.MustCompile(`^https://sqs\.([a-z0-9-]+)\.amazonaws\.com/(\d{12})/([A-Za-z0-9_-]+(\.fifo)?)$`)
+)
+
+// ruleTargets are the SNS topics and SQS queues alert rules may deliver to,
+// the consumer is only allowed to publish and send to them and the API
+// rejects rules targeting others
+type ruleTargets struct {
+	// Topic ARNs
+	Topics []string `json:"topics"`
+	// Queue URLs, the URLs rules are created with
+	Queues []string `json:"queues"`
+}
+
+// queueArns returns the ARNs of the queues, for their policy
+func (t ruleTargets) queueArns() []string {
+	arns := make([]string, 0, len(t.Queues))
+	for _, queue := range t.Queues {
+		match := queueUrl.FindStringSubmatch(queue)
+		arns = append(arns, fmt.Sprintf("arn:aws:sqs:%s:%s:%s", match[1], match[2], match[3]))
+	}
+	return arns
+}
+
+// list joins the targets into the RULE_TARGETS variable of the lambdas
+func (t ruleTargets) list() string {
+	return strings.Join(append(append([]string{}, t.Topics...), t.Queues...), ",")
+}
+
+// loadPipelineArgs reads the settings of the default pipeline from the stack
+// config, see Pulumi.dev.yaml and Pulumi.prod.yaml for examples
+func loadPipelineArgs(ctx *pulumi.Context) (*PipelineArgs, error) {
+	cfg := config.New(ctx, "")
+
+	// The provider reads aws:region itself, it is only checked here so a typo
+	// fails before anything is created
+	if region := config.New(ctx, "aws").Get("region"); region != "" && !awsRegion.MatchString(region) {
+		return nil, fmt.Errorf("aws:region %q is not an AWS region", region)
+	}
+
+	args := &PipelineArgs{
+		// Shared secret signing the webhook deliveries, set with
+		// pulumi config set --secret githubWebhookSecret <secret>
+		WebhookSecret: cfg.RequireSecret("githubWebhookSecret"),
+		// Aggregate tables, switch the live set with
+		// pulumi config set aggregateTables primary|shadow
+		LiveTables:   cfg.Get("aggregateTables"),
+		ShadowTables: cfg.GetBool("shadowTables"),
+		// Events wait in the queue while set, for the last replay before a
+		// swap over
+		PauseConsumer: cfg.GetBool("pauseConsumer"),
+		// Address notified of the alarms, it confirms the subscription
+		AlarmEmail: cfg.Get("alarmEmail"),
+	}
+	if args.LiveTables == "" {
+		args.LiveTables = primaryTables
+	}
+
+	// Optional GitHub token raising the enrichers rate limit, set with
+	// pulumi config set --secret githubToken <token>
+	githubToken, err := cfg.TrySecret("githubToken")
+	if err == nil {
+		args.GithubToken = githubToken
+	} else if !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("githubToken: %w", err)
+	}
+
+	fetchSchedule := cfg.Get("fetchSchedule")
+	if fetchSchedule == "" {
+		fetchSchedule = "rate(1000 minutes)"
+	}
+	if !scheduleExpression.MatchString(fetchSchedule) {
+		return nil, fmt.Errorf("fetchSchedule must be a rate() or cron() expression, got %q", fetchSchedule)
+	}
+	args.FetchSchedule = pulumi.String(fetchSchedule)
+
+	expiry, err := apiKeyExpiry(cfg.Get("apiKeyExpiry"), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	args.ApiKeyExpiry = pulumi.String(expiry.Format(time.RFC3339))
+
+	forceDestroy, err := cfg.TryBool("archiveForceDestroy")
+	if errors.Is(err, config.ErrMissingVar) {
+		forceDestroy = true
+	} else if err != nil {
+		return nil, fmt.Errorf("archiveForceDestroy: %w", err)
+	}
+	args.ForceDestroyArchive = forceDestroy
+
+	args.ConsumerBatchSize, err = intConfig(cfg, "consumerBatchSize", 10, 1, 10000)
+	if err != nil {
+		return nil, err
+	}
+	args.ActorEnricherBatchSize, err = intConfig(cfg, "actorEnricherBatchSize", 50, 1, 10000)
+	if err != nil {
+		return nil, err
+	}
+
+	args.Tables, err = loadTableSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	args.Lambdas, err = loadLambdaSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	args.RuleTargets, err = loadRuleTargets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// loadRuleTargets reads the ruleTargets object, without it rules can only
+// target webhooks
+func loadRuleTargets(cfg *config.Config) (ruleTargets, error) {
+	var targets ruleTargets
+	err := cfg.GetObject("ruleTargets", &targets)
+	if err != nil {
+		return targets, fmt.Errorf("ruleTargets: %w", err)
+	}
+	for _, topic := range targets.Topics {
+		if !topicArn.MatchString(topic) {
+			return targets, fmt.Errorf("ruleTargets.topics: %q is not an SNS topic ARN", topic)
+		}
+	}
+	for _, queue := range targets.Queues {
+		if !queueUrl.MatchString(queue) {
+			return targets, fmt.Errorf("ruleTargets.queues: %q is not an SQS queue URL", queue)
+		}
+	}
+	return targets, nil
+}
+
+// apiKeyExpiry parses the configured expiry of the API key. AppSync keys live
+// between a day and a year, the expiry is required so it only changes when
+// the key is rotated
+func apiKeyExpiry(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("apiKeyExpiry is required, set an RFC 3339 time at most a year ahead like 2024-08-31T00:00:00Z")
+	}
+
+	expiry, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("apiKeyExpiry must be an RFC 3339 time like 2024-08-31T00:00:00Z: %w", err)
+	}
+	if expiry.Before(now.Add(24 * time.Hour)) {
+		return time.Time{}, fmt.Errorf("apiKeyExpiry %s must be at least a day ahead", value)
+	}
+	if expiry.After(now.AddDate(1, 0, 0)) {
+		return time.Time{}, fmt.Errorf("apiKeyExpiry %s must be at most a year ahead", value)
+	}
+	return expiry, nil
+}
+
+func loadTableSettings(cfg *config.Config) (tableSettings, error) {
+	settings := tableSettings{BillingMode: cfg.Get("tableBillingMode")}
+	if settings.BillingMode == "" {
+		settings.BillingMode = payPerRequest
+	}
+	if settings.BillingMode != payPerRequest && settings.BillingMode != provisioned {
+		return settings, fmt.Errorf("tableBillingMode must be %q or %q, got %q", payPerRequest, provisioned, settings.BillingMode)
+	}
+
+	var err error
+	settings.ReadCapacity, err = intConfig(cfg, "tableReadCapacity", 5, 1, 40000)
+	if err != nil {
+		return settings, err
+	}
+	settings.WriteCapacity, err = intConfig(cfg, "tableWriteCapacity", 5, 1, 40000)
+	if err != nil {
+		return settings, err
+	}
+	return settings, nil
+}
+
+// loadLambdaSettings applies the lambdaMemory, logRetentionDays and logLevel
+// defaults and the per lambda overrides of the lambdas object to the default
+// settings
+func loadLambdaSettings(cfg *config.Config) (map[string]lambdaSettings, error) {
+	memory, err := intConfig(cfg, "lambdaMemory", 128, 128, 10240)
+	if err != nil {
+		return nil, err
+	}
+	retention, err := intConfig(cfg, "logRetentionDays", 14, 1, 3653)
+	if err != nil {
+		return nil, err
+	}
+	if !validLogRetention(retention) {
+		return nil, fmt.Errorf("logRetentionDays must be one of %v, got %d", logRetentionDays, retention)
+	}
+
+	logLevel := cfg.Get("logLevel")
+	if logLevel == "" {
+		logLevel = "info"
+	}
+	if !validLogLevel(logLevel) {
+		return nil, fmt.Errorf("logLevel must be one of %s, got %q", strings.Join(logLevels, ", "), logLevel)
+	}
+
+	runtime := cfg.Get("lambdaRuntime")
+	if runtime == "" {
+		runtime = lambdaRuntimes[0]
+	}
+	if runtime != lambdaRuntimes[0] && runtime != lambdaRuntimes[1] {
+		return nil, fmt.Errorf("lambdaRuntime must be one of %s, got %q", strings.Join(lambdaRuntimes, ", "), runtime)
+	}
+	architecture := cfg.Get("lambdaArchitecture")
+	if architecture == "" {
+		architecture = "arm64"
+	}
+	if _, ok := lambdaArchitectures[architecture]; !ok {
+		return nil, fmt.Errorf("lambdaArchitecture must be arm64 or x86_64, got %q", architecture)
+	}
+
+	// Stacks deployed before the log groups were managed import the groups
+	// Lambda created instead of failing to create them
+	importLogGroups := cfg.GetBool("importLogGroups")
+
+	var overrides map[string]json.RawMessage
+	err = cfg.GetObject("lambdas", &overrides)
+	if err != nil {
+		return nil, fmt.Errorf("lambdas: %w", err)
+	}
+
+	lambdas := make(map[string]lambdaSettings, len(defaultLambdas))
+	for name, settings := range defaultLambdas {
+		settings.Memory = memory
+		settings.LogRetentionDays = retention
+		settings.LogLevel = logLevel
+		settings.Runtime = runtime
+		settings.Architecture = architecture
+		settings.ImportLogGroup = importLogGroups
+		lambdas[name] = settings
+	}
+	for name, override := range overrides {
+		settings, ok := lambdas[name]
+		if !ok {
+			return nil, fmt.Errorf("lambdas: unknown lambda %q, expected one of %s", name, strings.Join(lambdaNames(), ", "))
+		}
+		err = json.Unmarshal(override, &settings)
+		if err != nil {
+			return nil, fmt.Errorf("lambdas.%s: %w", name, err)
+		}
+		lambdas[name] = settings
+	}
+
+	for name, settings := range lambdas {
+		err = settings.validate()
+		if err != nil {
+			return nil, fmt.Errorf("lambdas.%s: %w", name, err)
+		}
+	}
+	return lambdas, nil
+}
+
+func (s lambdaSettings) validate() error {
+	if s.Memory < 128 || s.Memory > 10240 {
+		return fmt.Errorf("memory must be between 128 and 10240 MB, got %d", s.Memory)
+	}
+	if s.Timeout < 1 || s.Timeout > 900 {
+		return fmt.Errorf("timeout must be between 1 and 900 seconds, got %d", s.Timeout)
+	}
+	if s.ReservedConcurrency < -1 {
+		return fmt.Errorf("reservedConcurrency must be -1 (unreserved) or more, got %d", s.ReservedConcurrency)
+	}
+	if !validLogRetention(s.LogRetentionDays) {
+		return fmt.Errorf("logRetentionDays must be one of %v, got %d", logRetentionDays, s.LogRetentionDays)
+	}
+	if !validLogLevel(s.LogLevel) {
+		return fmt.Errorf("logLevel must be one of %s, got %q", strings.Join(logLevels, ", "), s.LogLevel)
+	}
+	return nil
+}
+
+func validLogRetention(days int) bool {
+	for _, valid := range logRetentionDays {
+		if days == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func validLogLevel(level string) bool {
+	for _, valid := range logLevels {
+		if level == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func lambdaNames() []string {
+	names := make([]string, 0, len(defaultLambdas))
+	for name := range defaultLambdas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// intConfig reads an optional int, checking it is within [low, high]
+func intConfig(cfg *config.Config, key string, def, low, high int) (int, error) {
+	value, err := cfg.TryInt(key)
+	if errors.Is(err, config.ErrMissingVar) {
+		return def, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	if value < low || value > high {
+		return 0, fmt.Errorf("%s must be between %d and %d, got %d", key, low, high, value)
+	}
+	return value, nil
+}
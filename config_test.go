@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// loadArgs loads the pipeline args from the stack config under mocks
+func loadArgs(t *testing.T, config map[string]string) (*PipelineArgs, error) {
+	t.Helper()
+	var args *PipelineArgs
+	_, err := run(t, config, func(ctx *pulumi.Context) error {
+		var err error
+		args, err = loadPipelineArgs(ctx)
+		return err
+	})
+	return args, err
+}
+
+func TestLoadPipelineArgsDefaults(t *testing.T) {
+	args, err := loadArgs(t, map[string]string{"githubWebhookSecret": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.FetchSchedule != pulumi.String("rate(1000 minutes)") {
+		t.Errorf("fetchSchedule = %v", args.FetchSchedule)
+	}
+	if args.LiveTables != primaryTables || args.ShadowTables {
+		t.Errorf("tables = %s, shadow %v", args.LiveTables, args.ShadowTables)
+	}
+	if !args.ForceDestroyArchive {
+		t.Error("the archive is not force destroyed by default")
+	}
+	if args.ConsumerBatchSize != 10 || args.ActorEnricherBatchSize != 50 {
+		t.Errorf("batch sizes = %d, %d", args.ConsumerBatchSize, args.ActorEnricherBatchSize)
+	}
+	if args.Tables.BillingMode != payPerRequest {
+		t.Errorf("billing mode = %s", args.Tables.BillingMode)
+	}
+	if len(args.Lambdas) != len(defaultLambdas) {
+		t.Errorf("%d lambdas", len(args.Lambdas))
+	}
+	for name, settings := range args.Lambdas {
+		if settings.Timeout != defaultLambdas[name].Timeout || settings.Memory != 128 || settings.LogRetentionDays != 14 {
+			t.Errorf("%s settings = %+v", name, settings)
+		}
+		if settings.Runtime != "provided.al2023" || settings.Architecture != "arm64" {
+			t.Errorf("%s runs on %s %s", name, settings.Runtime, settings.Architecture)
+		}
+		if settings.LogLevel != "info" {
+			t.Errorf("%s logs at %s", name, settings.LogLevel)
+		}
+	}
+}
+
+func TestLoadPipelineArgsOverrides(t *testing.T) {
+	args, err := loadArgs(t, map[string]string{
+		"githubWebhookSecret": "secret",
+		"lambdaMemory":        "256",
+		"lambdaArchitecture":  "x86_64",
+		"logLevel":            "warn",
+		"lambdas":             `{"githubEventsConsumer":{"timeout":60,"memory":512,"logLevel":"debug"}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer := args.Lambdas["githubEventsConsumer"]
+	if consumer.Timeout != 60 || consumer.Memory != 512 || consumer.Architecture != "x86_64" || consumer.LogLevel != "debug" {
+		t.Errorf("consumer settings = %+v", consumer)
+	}
+	fetcher := args.Lambdas["githubEventsFetcher"]
+	if fetcher.Timeout != defaultLambdas["githubEventsFetcher"].Timeout || fetcher.Memory != 256 || fetcher.LogLevel != "warn" {
+		t.Errorf("fetcher settings = %+v", fetcher)
+	}
+}
+
+func TestLoadPipelineArgsErrors(t *testing.T) {
+	tests := []struct {
+		config map[string]string
+		err    string
+	}{
+		{map[string]string{"fetchSchedule": "every 10 minutes"}, "fetchSchedule must be a rate() or cron() expression"},
+		{map[string]string{"consumerBatchSize": "0"}, "consumerBatchSize must be between 1 and 10000"},
+		{map[string]string{"archiveForceDestroy": "maybe"}, "archiveForceDestroy"},
+		{map[string]string{"tableBillingMode": "ON_DEMAND"}, "tableBillingMode must be"},
+		{map[string]string{"logRetentionDays": "10"}, "logRetentionDays must be one of"},
+		{map[string]string{"lambdaRuntime": "go1.x"}, "lambdaRuntime must be one of"},
+		{map[string]string{"logLevel": "verbose"}, "logLevel must be one of"},
+		{map[string]string{"lambdas": `{"githubEventsConsumer":{"logLevel":"trace"}}`}, "lambdas.githubEventsConsumer: logLevel must be one of"},
+		{map[string]string{"lambdaArchitecture": "arm"}, "lambdaArchitecture must be arm64 or x86_64"},
+		{map[string]string{"lambdas": `{"fetcher":{"timeout":5}}`}, `lambdas: unknown lambda "fetcher"`},
+		{map[string]string{"lambdas": `{"githubEventsConsumer":{"timeout":1000}}`}, "lambdas.githubEventsConsumer: timeout must be between 1 and 900 seconds"},
+		{map[string]string{"apiKeyExpiry": "tomorrow"}, "apiKeyExpiry must be an RFC 3339 time"},
+		{map[string]string{"apiKeyExpiry": ""}, "apiKeyExpiry is required"},
+		{map[string]string{"ruleTargets": `{"topics":["alerts"]}`}, `ruleTargets.topics: "alerts" is not an SNS topic ARN`},
+		{map[string]string{"ruleTargets": `{"queues":["arn:aws:sqs:us-east-1:123456789012:alerts"]}`}, "ruleTargets.queues"},
+	}
+	for _, test := range tests {
+		t.Run(test.err, func(t *testing.T) {
+			test.config["githubWebhookSecret"] = "secret"
+			_, err := loadArgs(t, test.config)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestApiKeyExpiry(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+		err   string
+	}{
+		{"", time.Time{}, "apiKeyExpiry is required"},
+		{"2024-09-01T00:00:00Z", time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), ""},
+		{"2024-03-16T00:00:00Z", time.Time{}, "at least a day ahead"},
+		{"2025-04-01T00:00:00Z", time.Time{}, "at most a year ahead"},
+	}
+	for _, test := range tests {
+		got, err := apiKeyExpiry(test.value, now)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("apiKeyExpiry(%q) error = %v, want %q", test.value, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("apiKeyExpiry(%q) = %v, %v, want %v", test.value, got, err, test.want)
+		}
+	}
+}
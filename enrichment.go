@@ -0,0 +1,113 @@
+package main
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/dynamodb"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/sqs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type EventEnrichmentArgs struct {
+	Actors *dynamodb.Table
+	Repos  *dynamodb.Table
+	// Logins queued by the consumer for their profile
+	Queue *sqs.Queue
+	// Optional GitHub token raising the rate limit
+	GithubToken pulumi.StringInput
+	// Logins passed to each actorEnricher invocation
+	BatchSize     int
+	ActorEnricher lambdaSettings
+	RepoEnricher  lambdaSettings
+}
+
+// EventEnrichment completes the actors and repos aggregated by the consumer
+// with their GitHub profile and metadata
+type EventEnrichment struct {
+	pulumi.ResourceState
+	component
+
+	ActorEnricher *lambda.Function
+	RepoEnricher  *lambda.Function
+}
+
+func NewEventEnrichment(ctx *pulumi.Context, name string, args *EventEnrichmentArgs, opts ...pulumi.ResourceOption) (*EventEnrichment, error) {
+	c := &EventEnrichment{}
+	c.component = component{name: name, resource: c}
+	err := ctx.RegisterComponentResource("pointfive:pipeline:EventEnrichment", name, c, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both enrichers read the token from its parameter, without token they
+	// make unauthenticated requests
+	repoEnricherStatements := []policyStatement{allowTables([]string{"Query", "UpdateItem"}, args.Repos)}
+	actorEnricherStatements := []policyStatement{
+		allowQueueConsume(args.Queue),
+		allowTables([]string{"GetItem", "UpdateItem"}, args.Actors),
+	}
+	repoEnricherVariables := pulumi.StringMap{"REPOS_TABLE": args.Repos.Name}
+	actorEnricherVariables := pulumi.StringMap{"ACTORS_TABLE": args.Actors.Name}
+	if args.GithubToken != nil {
+		tokenParameter, err := c.newSecret(ctx, "githubToken", args.GithubToken)
+		if err != nil {
+			return nil, err
+		}
+		repoEnricherStatements = append(repoEnricherStatements, allowParameterRead(tokenParameter))
+		actorEnricherStatements = append(actorEnricherStatements, allowParameterRead(tokenParameter))
+		repoEnricherVariables["GITHUB_TOKEN_PARAMETER"] = tokenParameter.Name
+		actorEnricherVariables["GITHUB_TOKEN_PARAMETER"] = tokenParameter.Name
+	}
+
+	repoEnricherRole, err := c.newLambdaRole(ctx, "repoEnricherRole", repoEnricherStatements...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.RepoEnricher, err = c.newFunction(ctx, "repoEnricher", args.RepoEnricher, &lambda.FunctionArgs{
+		Role:        repoEnricherRole.Arn,
+		Environment: &lambda.FunctionEnvironmentArgs{Variables: repoEnricherVariables},
+	}, pulumi.DependsOn([]pulumi.Resource{args.Repos}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Refresh stale repo metadata every 15 minutes
+	err = c.newSchedule(ctx, "everyFifteenMinutes", pulumi.String("rate(15 minutes)"), c.RepoEnricher, "repoEnricher")
+	if err != nil {
+		return nil, err
+	}
+
+	actorEnricherRole, err := c.newLambdaRole(ctx, "actorEnricherRole", actorEnricherStatements...)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single concurrent enricher keeps the GitHub rate limit budget predictable
+	c.ActorEnricher, err = c.newFunction(ctx, "actorEnricher", args.ActorEnricher, &lambda.FunctionArgs{
+		Role:        actorEnricherRole.Arn,
+		Environment: &lambda.FunctionEnvironmentArgs{Variables: actorEnricherVariables},
+	}, pulumi.DependsOn([]pulumi.Resource{args.Actors, args.Queue}))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = lambda.NewEventSourceMapping(ctx, c.resourceName("invokeActorEnricherLambda"), &lambda.EventSourceMappingArgs{
+		EventSourceArn:                 args.Queue.Arn,
+		FunctionName:                   c.ActorEnricher.Name,
+		BatchSize:                      pulumi.Int(args.BatchSize),
+		MaximumBatchingWindowInSeconds: batchingWindow(args.BatchSize),
+		FunctionResponseTypes:          pulumi.StringArray{pulumi.String("ReportBatchItemFailures")},
+	}, c.childOptions("invokeActorEnricherLambda", pulumi.DependsOn([]pulumi.Resource{c.ActorEnricher}))...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(c, pulumi.Map{
+		"actorEnricher": c.ActorEnricher.Arn,
+		"repoEnricher":  c.RepoEnricher.Arn,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
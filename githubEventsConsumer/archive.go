@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/firehose"
+)
+
+var archiveStreamName string
+
+var firehoseClient *firehose.Firehose
+
+// archiveRecord is the raw event as stored in the data lake. Firehose
+// converts it to Parquet with the githubEvents Glue table schema and
+// partitions it by its Dt and Type fields
+type archiveRecord struct {
+	ActorLogin string `json:"actor_login"`
+	ActorName  string `json:"actor_name"`
+	ActorEmail string `json:"actor_email"`
+	RepoName   string `json:"repo_name"`
+	RepoUrl    string `json:"repo_url"`
+	RepoId     int64  `json:"repo_id"`
+	EventType  string `json:"event_type"`
+	CreatedAt  string `json:"created_at"`
+	IsBot      bool   `json:"is_bot"`
+	Backfill   bool   `json:"backfill"`
+	Dt         string `json:"dt"`
+	Type       string `json:"type"`
+}
+
+// Events of the SQS batch waiting to be archived
+var pendingArchive []*firehose.Record
+
+func archiveEvent(event common.Github_event, class actorClass) error {
+	t := eventTime(event).UTC()
+	record, err := json.Marshal(archiveRecord{
+		ActorLogin: event.ActorLogin,
+		ActorName:  event.ActorName,
+		ActorEmail: event.ActorEmail,
+		RepoName:   event.RepoName,
+		RepoUrl:    event.RepoUrl,
+		RepoId:     event.RepoId,
+		EventType:  event.EventType,
+		CreatedAt:  t.Format(common.ArchiveTimestampFormat),
+		IsBot:      class.IsBot,
+		Backfill:   event.Backfill,
+		Dt:         t.Format(common.ArchiveDateFormat),
+		Type:       common.ArchivePartitionType(event.EventType),
+	})
+	if err != nil {
+		return err
+	}
+	pendingArchive = append(pendingArchive, &firehose.Record{Data: record})
+	return nil
+}
+
+// PutRecordBatch accepts at most 500 records and 4 MiB in a request
+const (
+	archiveBatchRecords = 500
+	archiveBatchBytes   = 4 << 20
+)
+
+// Attempts of the records Firehose failed to ingest, waiting twice as long
+// before each one
+const (
+	archiveAttempts     = 4
+	archiveRetryBackoff = 100 * time.Millisecond
+)
+
+// flushArchive sends the pending events to the archive stream in batches
+// within the PutRecordBatch limits
+func flushArchive() error {
+	records := pendingArchive
+	pendingArchive = nil
+
+	failed := 0
+	var lastErr error
+	for _, batch := range archiveBatches(records) {
+		unarchived, err := putArchiveBatch(batch)
+		if err != nil {
+			lastErr = err
+		}
+		failed += unarchived
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("failed to archive %d events: %w", failed, lastErr)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to archive %d events", failed)
+	}
+	return nil
+}
+
+// archiveBatches splits the records in batches of at most
+// archiveBatchRecords records and archiveBatchBytes bytes
+func archiveBatches(records []*firehose.Record) [][]*firehose.Record {
+	var batches [][]*firehose.Record
+	var batch []*firehose.Record
+	size := 0
+	for _, record := range records {
+		if len(batch) == archiveBatchRecords || (len(batch) > 0 && size+len(record.Data) > archiveBatchBytes) {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, record)
+		size += len(record.Data)
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// putArchiveBatch sends a batch to the archive stream, retrying the records
+// Firehose failed to ingest, and returns how many were not archived
+func putArchiveBatch(records []*firehose.Record) (int, error) {
+	backoff := archiveRetryBackoff
+	for attempt := 1; ; attempt++ {
+		result, err := firehoseClient.PutRecordBatch(&firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(archiveStreamName),
+			Records:            records,
+		})
+		if err != nil {
+			return len(records), err
+		}
+		if aws.Int64Value(result.FailedPutCount) == 0 {
+			return 0, nil
+		}
+
+		var failed []*firehose.Record
+		for index, response := range result.RequestResponses {
+			if response.ErrorCode != nil {
+				failed = append(failed, records[index])
+			}
+		}
+		records = failed
+		if attempt == archiveAttempts {
+			return len(records), nil
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
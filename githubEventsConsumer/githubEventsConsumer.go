@@ -3,8 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ahmads/common"
@@ -15,37 +19,111 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
 var eventCountTableName string
 var actorTableName string
 var reposTableName string
+var leaderboardTableName string
+var repoActivityTableName string
+var actorEnrichmentQueueUrl string
 
 var db *dynamodb.DynamoDB
 
+// Metrics of the invocations, replays publish none
+var metrics *common.Metrics
+
+var logger = common.NewLogger()
+
+// Actors producing more events than this within a minute are classified as bots
+const botEventsPerMinute = 20
+
+// actorClass is the bot classification of the actor of an event
+type actorClass struct {
+	IsBot  bool
+	Reason string
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			fmt.Println("replay failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate-kind" {
+		if err := runMigrateKind(os.Args[2:]); err != nil {
+			fmt.Println("migration failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	eventCountTableName = os.Getenv("EVENTS_COUNT_TABLE")
 	if eventCountTableName == "" {
-		fmt.Println("EVENTS_COUNT_TABLE environment variable not set")
+		logger.Error("EVENTS_COUNT_TABLE environment variable not set")
 		os.Exit(1)
 	}
-	fmt.Println("EVENTS_COUNT_TABLE is set to", eventCountTableName)
+	logger.Info("EVENTS_COUNT_TABLE is set", "value", eventCountTableName)
 
 	actorTableName = os.Getenv("ACTORS_TABLE")
 	if actorTableName == "" {
-		fmt.Println("ACTORS_TABLE environment variable not set")
+		logger.Error("ACTORS_TABLE environment variable not set")
 		os.Exit(1)
 	}
-	fmt.Println("ACTORS_TABLE is set to", actorTableName)
+	logger.Info("ACTORS_TABLE is set", "value", actorTableName)
 
 	reposTableName = os.Getenv("REPOS_TABLE")
 	if reposTableName == "" {
-		fmt.Println("reposTableName environment variable not set")
+		logger.Error("reposTableName environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("reposTableName is set", "value", reposTableName)
+
+	leaderboardTableName = os.Getenv("LEADERBOARD_TABLE")
+	if leaderboardTableName == "" {
+		logger.Error("LEADERBOARD_TABLE environment variable not set")
 		os.Exit(1)
 	}
-	fmt.Println("reposTableName is set to", reposTableName)
+	logger.Info("LEADERBOARD_TABLE is set", "value", leaderboardTableName)
 
+	repoActivityTableName = os.Getenv("REPO_ACTIVITY_TABLE")
+	if repoActivityTableName == "" {
+		logger.Error("REPO_ACTIVITY_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("REPO_ACTIVITY_TABLE is set", "value", repoActivityTableName)
+
+	rulesTableName = os.Getenv("RULES_TABLE")
+	if rulesTableName == "" {
+		logger.Error("RULES_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("RULES_TABLE is set", "value", rulesTableName)
+
+	// Without targets, rules can only deliver to webhooks
+	ruleTargets = common.ParseRuleTargets(os.Getenv("RULE_TARGETS"))
+	logger.Info("RULE_TARGETS is set", "value", os.Getenv("RULE_TARGETS"))
+
+	actorEnrichmentQueueUrl = os.Getenv("ACTOR_ENRICHMENT_QUEUE_URL")
+	if actorEnrichmentQueueUrl == "" {
+		logger.Error("ACTOR_ENRICHMENT_QUEUE_URL environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("ACTOR_ENRICHMENT_QUEUE_URL is set", "value", actorEnrichmentQueueUrl)
+
+	archiveStreamName = os.Getenv("ARCHIVE_STREAM_NAME")
+	if archiveStreamName == "" {
+		logger.Error("ARCHIVE_STREAM_NAME environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("ARCHIVE_STREAM_NAME is set", "value", archiveStreamName)
+
+	metrics = common.NewMetrics()
 	initDynamoDb()
 	lambda.Start(handler)
 }
@@ -56,38 +134,265 @@ func initDynamoDb() error {
 		return err
 	}
 	db = dynamodb.New(sess)
+	metrics.TrackLatency(&db.Handlers, "DynamoDBLatency")
+	snsClient = sns.New(sess)
+	sqsClient = sqs.New(sess)
+	firehoseClient = firehose.New(sess)
 	return nil
 }
 
-func handler(ctx context.Context, sqsEvent events.SQSEvent) error {
+// handler consumes the events of the batch, reporting the invalid messages
+// and the events it failed to save as batch item failures so SQS delivers
+// them again, and moves them to the dead-letter queue once they failed too often
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	defer metrics.Flush()
+	defer waitWebhookDeliveries()
+	// Drop what a failed invocation left, SQS delivers its batch again
+	pendingArchive = nil
+	response := events.SQSEventResponse{}
+	requestLog := common.RequestLogger(ctx, logger)
 	for _, record := range sqsEvent.Records {
-		// Process each SQS message
-		fmt.Printf("Message ID: %s\n", record.MessageId)
-		fmt.Printf("Message Body: %s\n", record.Body)
+		log := messageLogger(requestLog, record)
 		var githubEvent common.Github_event
 		err := json.Unmarshal([]byte(record.Body), &githubEvent)
-		fmt.Println("Message :", githubEvent)
-
 		if err != nil {
-			fmt.Println("Error:", err)
-			return err
+			log.Error("invalid message", "error", err, "body", record.Body)
+			metrics.Count("InvalidMessages", 1)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+			continue
+		}
+		log = log.With(common.LogEventId, githubEvent.EventId)
+		log.Debug("consuming event", "body", record.Body)
+		if err := handleEvent(log, githubEvent); err != nil {
+			log.Error("failed to consume event", "type", githubEvent.EventType, "error", err)
+			metrics.Count("EventWriteFailures", 1)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+			continue
+		}
+		log.Info("consumed event", "type", githubEvent.EventType, "repo", githubEvent.RepoName, "actor", githubEvent.ActorLogin)
+		// Types only sent by webhooks are counted as Other, like in the archive
+		metrics.Count("EventsConsumed", 1, common.Dimension{Name: "EventType", Value: common.ArchivePartitionType(githubEvent.EventType)})
+	}
+
+	// Losing archived events is preferred to counting the batch twice
+	if err := flushArchive(); err != nil {
+		requestLog.Error("failed to archive events", "error", err)
+	}
+	return response, nil
+}
+
+// messageLogger adds the ID of the message and the correlation ID its
+// producer sent along to the logger
+func messageLogger(log *slog.Logger, record events.SQSMessage) *slog.Logger {
+	log = log.With(common.LogMessageId, record.MessageId)
+	if attribute, ok := record.MessageAttributes[common.CorrelationIdAttribute]; ok && attribute.StringValue != nil {
+		log = log.With(common.LogCorrelationId, *attribute.StringValue)
+	}
+	return log
+}
+
+// handleEvent updates the tables with the event. It stops at the first
+// failed update, SQS then delivers the event again and the updates made
+// before the failure count it twice. Only saved events are archived and
+// evaluated against the alert rules.
+func handleEvent(log *slog.Logger, event common.Github_event) error {
+	class := classifyActor(log, event)
+	if err := createOrUpdateEventCount(event.EventType, class); err != nil {
+		return fmt.Errorf("failed to count event: %w", err)
+	}
+	if err := createOrUpdateActor(event, class); err != nil {
+		return fmt.Errorf("failed to update actor %s: %w", event.ActorLogin, err)
+	}
+	language, err := createOrUpdateRepo(event, class)
+	if err != nil {
+		return fmt.Errorf("failed to update repo %s: %w", event.RepoUrl, err)
+	}
+	if err := updateLeaderboards(event, language, class); err != nil {
+		return fmt.Errorf("failed to update leaderboards: %w", err)
+	}
+	if err := updateRepoActivity(event, class); err != nil {
+		return fmt.Errorf("failed to update repo activity of %s: %w", event.RepoName, err)
+	}
+	if replaying {
+		return nil
+	}
+	if err := archiveEvent(event, class); err != nil {
+		log.Error("failed to archive event", "error", err)
+	}
+	if !event.Backfill {
+		evaluateRules(log, event)
+	}
+	return nil
+}
+
+// classifyActor tells bots from humans by their login first, then by how many
+// events they produced within the minute of the event
+func classifyActor(log *slog.Logger, event common.Github_event) actorClass {
+	if isBot, reason := common.ClassifyLogin(event.ActorLogin); isBot {
+		return actorClass{IsBot: true, Reason: reason}
+	}
+
+	count, err := trackActorRate(event.ActorLogin, eventTime(event))
+	if err != nil {
+		log.Warn("failed to track actor rate", "actor", event.ActorLogin, "error", err)
+		return actorClass{}
+	}
+	if count > botEventsPerMinute {
+		return actorClass{IsBot: true, Reason: common.BotReasonActivityRate}
+	}
+	return actorClass{}
+}
+
+// trackActorRate counts the actor's events within the minute of t on the
+// actor record and returns the count so far
+func trackActorRate(login string, t time.Time) (int, error) {
+	if login == "" {
+		return 0, nil
+	}
+	minute := &dynamodb.AttributeValue{
+		N: aws.String(fmt.Sprintf("%d", t.Unix()/60)),
+	}
+	key := map[string]*dynamodb.AttributeValue{
+		"Login": {S: aws.String(login)},
+	}
+
+	// Attempt to count in the current minute
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(actorTableName),
+		Key:                 key,
+		UpdateExpression:    aws.String("ADD RateCount :increment"),
+		ConditionExpression: aws.String("RateMinute = :minute"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":increment": {N: aws.String("1")},
+			":minute":    minute,
+		},
+		ReturnValues: aws.String("UPDATED_NEW"),
+	}
+
+	result, err := db.UpdateItem(updateInput)
+	if err == nil {
+		return strconv.Atoi(aws.StringValue(result.Attributes["RateCount"].N))
+	}
+
+	// If the condition fails, start counting a new minute
+	updateInput = &dynamodb.UpdateItemInput{
+		TableName:        aws.String(actorTableName),
+		Key:              key,
+		UpdateExpression: aws.String("SET RateMinute = :minute, RateCount = :increment"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":increment": {N: aws.String("1")},
+			":minute":    minute,
+		},
+		ReturnValues: aws.String("NONE"),
+	}
+
+	_, err = db.UpdateItem(updateInput)
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// humanIncrement is what an event adds to the human only counters
+func humanIncrement(class actorClass, value int) *dynamodb.AttributeValue {
+	if class.IsBot {
+		value = 0
+	}
+	return &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", value))}
+}
+
+// eventTime returns when the event happened, falling back to now for
+// messages produced before CreatedAt was added
+func eventTime(event common.Github_event) time.Time {
+	if event.CreatedAt.IsZero() {
+		return time.Now()
+	}
+	return event.CreatedAt
+}
+
+// updateLeaderboards increments the repo, actor, event type and repo language
+// members of every window the event falls in, and of their human only
+// variants for events of human actors
+func updateLeaderboards(event common.Github_event, language string, class actorClass) error {
+	members := map[string]string{
+		common.BoardRepos:      event.RepoName,
+		common.BoardActors:     event.ActorLogin,
+		common.BoardEventTypes: event.EventType,
+		common.BoardLanguages:  language,
+	}
+	t := eventTime(event)
+
+	for dimension, member := range members {
+		if member == "" {
+			continue
+		}
+		for _, window := range common.Windows {
+			board, err := common.BoardKey(dimension, window, t)
+			if err != nil {
+				return err
+			}
+			err = incrementLeaderboard(board, member, common.BoardExpiry(window, t))
+			if err != nil {
+				return err
+			}
+			if class.IsBot {
+				continue
+			}
+			board, err = common.BoardKey(common.HumanOnly(dimension), window, t)
+			if err != nil {
+				return err
+			}
+			err = incrementLeaderboard(board, member, common.BoardExpiry(window, t))
+			if err != nil {
+				return err
+			}
 		}
-		handleEvent(githubEvent)
 	}
 	return nil
 }
 
-func handleEvent(event common.Github_event) {
-	createOrUpdateEventCount(event.EventType)
-	createOrUpdateActor(event)
-	createOrUpdateRepo(event)
+func incrementLeaderboard(board string, member string, expiresAt time.Time) error {
+	updateExpression := "ADD #count :increment"
+	expressionAttributeNames := map[string]*string{
+		"#count": aws.String("Count"),
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":increment": {
+			N: aws.String("1"),
+		},
+	}
+
+	if !expiresAt.IsZero() {
+		updateExpression += " SET ExpiresAt = :expiresAt"
+		expressionAttributeValues[":expiresAt"] = &dynamodb.AttributeValue{
+			N: aws.String(fmt.Sprintf("%d", expiresAt.Unix())),
+		}
+	}
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(leaderboardTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Board":  {S: aws.String(board)},
+			"Member": {S: aws.String(member)},
+		},
+		UpdateExpression:          aws.String(updateExpression),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		ReturnValues:              aws.String("NONE"),
+	}
+
+	_, err := db.UpdateItem(updateInput)
+	if err != nil {
+		return err
+	}
+	return nil
 }
 
-func createOrUpdateEventCount(eventType string) error {
+func createOrUpdateEventCount(eventType string, class actorClass) error {
 
 	incrementValue := 1
 	conditionExpression := "attribute_exists(EventType)"
-	updateExpression := "SET #count = #count + :increment"
+	updateExpression := "SET #count = #count + :increment, Kind = :kind ADD HumanCount :humanIncrement"
 	expressionAttributeNames := map[string]*string{
 		"#count": aws.String("Count"),
 	}
@@ -95,6 +400,10 @@ func createOrUpdateEventCount(eventType string) error {
 		":increment": {
 			N: aws.String(fmt.Sprintf("%d", incrementValue)),
 		},
+		":humanIncrement": humanIncrement(class, incrementValue),
+		":kind": {
+			S: aws.String(common.KindEvent),
+		},
 	}
 
 	// Attempt to update the existing record
@@ -112,65 +421,161 @@ func createOrUpdateEventCount(eventType string) error {
 
 	_, err := db.UpdateItem(updateInput)
 	if err == nil {
-		fmt.Println("Update successful")
 		return nil
 	} else {
 		// If the condition fails, create the record with an initial 'count' value of 1
 		putInput := &dynamodb.PutItemInput{
 			TableName: aws.String(eventCountTableName),
 			Item: map[string]*dynamodb.AttributeValue{
-				"EventType": {S: aws.String(eventType)},
-				"Count":     {N: aws.String(fmt.Sprintf("%d", incrementValue))},
+				"EventType":  {S: aws.String(eventType)},
+				"Count":      {N: aws.String(fmt.Sprintf("%d", incrementValue))},
+				"HumanCount": humanIncrement(class, incrementValue),
+				"Kind":       {S: aws.String(common.KindEvent)},
 			},
 		}
 
 		_, err := db.PutItem(putInput)
 		if err != nil {
-			fmt.Println("Error:", err)
 			return err
 		}
-		fmt.Println("Record created")
 	}
 	return nil
 }
 
-func createOrUpdateActor(event common.Github_event) error {
+// createOrUpdateActor requests the enrichment of the actor's profile the
+// first time the actor is seen live, backfilled actors are only enriched
+// once they show up in a live event
+func createOrUpdateActor(event common.Github_event, class actorClass) error {
 
-	updateExpression := "SET LastAction = :lastAction, Email = :email, ActorName = :name"
+	// Once classified as a bot an actor stays one, even when its rate drops
+	setClauses := []string{"Kind = :kind", "IsBot = if_not_exists(IsBot, :isBot)"}
+	if class.IsBot {
+		setClauses = []string{"Kind = :kind", "IsBot = :isBot", "BotReason = :botReason"}
+	}
 
 	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
-		":lastAction": {
-			N: aws.String(fmt.Sprintf("%d", time.Now().Unix())),
+		":isBot": {
+			BOOL: aws.Bool(class.IsBot),
 		},
-		":email": {
-			S: aws.String(event.ActorEmail),
+		":kind": {
+			S: aws.String(common.KindActor),
 		},
-		":name": {
+	}
+	if class.IsBot {
+		expressionAttributeValues[":botReason"] = &dynamodb.AttributeValue{
+			S: aws.String(class.Reason),
+		}
+	}
+	if !outOfOrder(event) {
+		setClauses = append(setClauses, "LastAction = :lastAction")
+		expressionAttributeValues[":lastAction"] = &dynamodb.AttributeValue{
+			N: aws.String(fmt.Sprintf("%d", eventTime(event).Unix())),
+		}
+	}
+	if !event.Backfill {
+		setClauses = append(setClauses, "EnrichmentRequested = if_not_exists(EnrichmentRequested, :requested)")
+		expressionAttributeValues[":requested"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+
+	// The events API leaves names and emails empty, keep the ones filled in
+	// by the actorEnricher
+	if event.ActorEmail != "" {
+		setClauses = append(setClauses, "Email = :email")
+		expressionAttributeValues[":email"] = &dynamodb.AttributeValue{
+			S: aws.String(event.ActorEmail),
+		}
+	}
+	if event.ActorName != "" {
+		setClauses = append(setClauses, "ActorName = :name")
+		expressionAttributeValues[":name"] = &dynamodb.AttributeValue{
 			S: aws.String(event.ActorName),
-		},
+		}
 	}
 
+	key := map[string]*dynamodb.AttributeValue{
+		"Login": {S: aws.String(event.ActorLogin)},
+	}
 	updateInput := &dynamodb.UpdateItemInput{
-		TableName: aws.String(actorTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"Login": {S: aws.String(event.ActorLogin)},
-		},
-		UpdateExpression:          aws.String(updateExpression),
+		TableName:                 aws.String(actorTableName),
+		Key:                       key,
+		UpdateExpression:          aws.String("SET " + strings.Join(setClauses, ", ")),
 		ExpressionAttributeValues: expressionAttributeValues,
-		ReturnValues:              aws.String("NONE"),
+		ReturnValues:              aws.String("UPDATED_OLD"),
 	}
 
-	_, err := db.UpdateItem(updateInput)
+	result, err := db.UpdateItem(updateInput)
+	if err != nil {
+		return err
+	}
+	if outOfOrder(event) {
+		if err := setLatest(actorTableName, key, "LastAction", eventTime(event)); err != nil {
+			return err
+		}
+	}
+
+	if _, requested := result.Attributes["EnrichmentRequested"]; !requested && !event.Backfill && !replaying {
+		return requestActorEnrichment(event.ActorLogin)
+	}
+	return nil
+}
+
+// outOfOrder tells whether the event may be older than the events already
+// consumed, backfilled and replayed events are
+func outOfOrder(event common.Github_event) bool {
+	return event.Backfill || replaying
+}
+
+// setLatest sets the time attribute of the item, unless it already holds a
+// later time
+func setLatest(table string, key map[string]*dynamodb.AttributeValue, attribute string, t time.Time) error {
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(table),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET #time = :time"),
+		ConditionExpression: aws.String("attribute_not_exists(#time) OR #time < :time"),
+		ExpressionAttributeNames: map[string]*string{
+			"#time": aws.String(attribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":time": {N: aws.String(fmt.Sprintf("%d", t.Unix()))},
+		},
+	})
+	var conditionFailed *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	return err
+}
+
+// requestActorEnrichment queues the login for the actorEnricher
+func requestActorEnrichment(login string) error {
+	message, err := json.Marshal(common.ActorEnrichmentRequest{Login: login})
+	if err != nil {
+		return err
+	}
+
+	_, err = sqsClient.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(actorEnrichmentQueueUrl),
+		MessageBody: aws.String(string(message)),
+	})
 	if err != nil {
-		fmt.Println("Error:", err)
 		return err
 	}
 	return nil
 }
 
-func createOrUpdateRepo(event common.Github_event) error {
+// createOrUpdateRepo returns the language of the repo, known once the
+// repoEnricher refreshed it
+func createOrUpdateRepo(event common.Github_event, class actorClass) (string, error) {
 
-	updateExpression := "SET RepoName = :repoName, RepoId = :repoId"
+	setClauses := []string{"Kind = :kind", "RepoName = :repoName", "RepoId = :repoId"}
+	if !outOfOrder(event) {
+		setClauses = append(setClauses, "LastActivity = :lastActivity")
+	}
+	updateExpression := "SET " + strings.Join(setClauses, ", ") + " ADD HumanEvents :humanIncrement, #eventType :increment"
+	expressionAttributeNames := map[string]*string{
+		"#eventType": aws.String("Type_" + event.EventType),
+	}
 
 	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
 		":repoName": {
@@ -179,21 +584,86 @@ func createOrUpdateRepo(event common.Github_event) error {
 		":repoId": {
 			N: aws.String(fmt.Sprintf("%d", event.RepoId)),
 		},
+		":humanIncrement": humanIncrement(class, 1),
+		":increment": {
+			N: aws.String("1"),
+		},
+		":kind": {
+			S: aws.String(common.KindRepo),
+		},
+	}
+	if !outOfOrder(event) {
+		expressionAttributeValues[":lastActivity"] = &dynamodb.AttributeValue{
+			N: aws.String(fmt.Sprintf("%d", eventTime(event).Unix())),
+		}
 	}
 
+	key := map[string]*dynamodb.AttributeValue{
+		"RepoUrl": {S: aws.String(event.RepoUrl)},
+	}
 	updateInput := &dynamodb.UpdateItemInput{
-		TableName: aws.String(reposTableName),
+		TableName:                 aws.String(reposTableName),
+		Key:                       key,
+		UpdateExpression:          aws.String(updateExpression),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		ReturnValues:              aws.String("ALL_NEW"),
+	}
+
+	result, err := db.UpdateItem(updateInput)
+	if err != nil {
+		return "", err
+	}
+	if outOfOrder(event) {
+		if err := setLatest(reposTableName, key, "LastActivity", eventTime(event)); err != nil {
+			return "", err
+		}
+	}
+	if language, ok := result.Attributes["Language"]; ok {
+		return aws.StringValue(language.S), nil
+	}
+	return "", nil
+}
+
+// updateRepoActivity counts the event in the repo's hourly activity bucket,
+// both in total and per event type, for the trending aggregator
+func updateRepoActivity(event common.Github_event, class actorClass) error {
+	if event.RepoName == "" {
+		return nil
+	}
+	t := eventTime(event)
+
+	updateExpression := "ADD Total :increment, Weighted :weight, HumanWeighted :humanWeight, #eventType :increment SET ExpiresAt = :expiresAt"
+	expressionAttributeNames := map[string]*string{
+		"#eventType": aws.String("Type_" + event.EventType),
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":increment": {
+			N: aws.String("1"),
+		},
+		":weight": {
+			N: aws.String(fmt.Sprintf("%d", common.EventWeight(event.EventType))),
+		},
+		":humanWeight": humanIncrement(class, common.EventWeight(event.EventType)),
+		":expiresAt": {
+			N: aws.String(fmt.Sprintf("%d", t.Add(common.RepoActivityRetention).Unix())),
+		},
+	}
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(repoActivityTableName),
 		Key: map[string]*dynamodb.AttributeValue{
-			"RepoUrl": {S: aws.String(event.RepoUrl)},
+			"RepoName": {S: aws.String(event.RepoName)},
+			"Bucket":   {S: aws.String(common.HourBucket(t))},
 		},
 		UpdateExpression:          aws.String(updateExpression),
+		ExpressionAttributeNames:  expressionAttributeNames,
 		ExpressionAttributeValues: expressionAttributeValues,
 		ReturnValues:              aws.String("NONE"),
 	}
 
 	_, err := db.UpdateItem(updateInput)
 	if err != nil {
-		fmt.Println("Error:", err)
 		return err
 	}
 	return nil
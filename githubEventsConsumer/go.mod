@@ -4,15 +4,23 @@ go 1.21.1
 
 require github.com/aws/aws-lambda-go v1.41.0
 
-require github.com/ahmads/common v0.0.0
+require (
+	github.com/ahmads/common v0.0.0
+	github.com/aws/aws-sdk-go v1.45.11
+	github.com/parquet-go/parquet-go v0.23.0
+)
 
 require (
-	github.com/aws/aws-sdk-go v1.45.11 // indirect
-	github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue v1.10.39 // indirect
-	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.21.5 // indirect
-	github.com/aws/aws-sdk-go-v2/service/dynamodbstreams v1.15.5 // indirect
-	github.com/aws/smithy-go v1.14.2 // indirect
+	github.com/andybalholm/brotli v1.1.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/mattn/go-runewidth v0.0.15 // indirect
+	github.com/olekukonko/tablewriter v0.0.5 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
+	github.com/segmentio/encoding v0.4.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
 )
 
 replace github.com/ahmads/common => ../common
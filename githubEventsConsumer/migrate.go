@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// kindTable is a table whose items the ordered indexes find by their Kind
+// and sort by their SortKeys
+type kindTable struct {
+	Variable string
+	HashKey  string
+	Kind     string
+	// Numeric sort keys of the indexes, older items may lack them
+	SortKeys []string
+}
+
+var kindTables = []kindTable{
+	{"ACTORS_TABLE", "Login", common.KindActor, []string{"LastAction"}},
+	{"REPOS_TABLE", "RepoUrl", common.KindRepo, []string{"LastActivity"}},
+	{"EVENTS_COUNT_TABLE", "EventType", common.KindEvent, []string{"HumanCount"}},
+}
+
+// runMigrateKind sets the Kind attribute and the index sort keys on the items
+// written before the consumer set them, so the ordered indexes find them
+// without waiting for their next event. Missing sort keys are set to 0: repos
+// last active before the migration sort as the least recently active ones and
+// events counted before it as no human events.
+//
+//	githubEventsConsumer migrate-kind -tables tables.json
+//
+// tables.json maps the table environment variables to the tables, as
+// exported by the aggregateTables (or replayTables) stack output. Running it
+// again only updates the items still missing Kind or a sort key.
+func runMigrateKind(args []string) error {
+	flags := flag.NewFlagSet("migrate-kind", flag.ExitOnError)
+	tablesFile := flags.String("tables", "", "JSON file mapping the table environment variables to the tables to migrate")
+	flags.Parse(args)
+
+	if *tablesFile == "" {
+		flags.Usage()
+		return errors.New("-tables is required")
+	}
+	data, err := os.ReadFile(*tablesFile)
+	if err != nil {
+		return err
+	}
+	var tables map[string]string
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return fmt.Errorf("invalid tables file %s: %w", *tablesFile, err)
+	}
+	for _, table := range kindTables {
+		if tables[table.Variable] == "" {
+			return fmt.Errorf("tables file %s has no %s", *tablesFile, table.Variable)
+		}
+	}
+
+	if err := initDynamoDb(); err != nil {
+		return err
+	}
+	for _, table := range kindTables {
+		migrated, err := migrateKind(tables[table.Variable], table)
+		if err != nil {
+			return fmt.Errorf("%s: %w", tables[table.Variable], err)
+		}
+		fmt.Println("set Kind and sort keys on", migrated, "items of", tables[table.Variable])
+	}
+	return nil
+}
+
+// migrateKind sets Kind and the sort keys on the items of the table missing
+// them, returning how many were updated
+func migrateKind(tableName string, table kindTable) (int, error) {
+	names := map[string]*string{"#key": aws.String(table.HashKey)}
+	values := map[string]*dynamodb.AttributeValue{
+		":kind": {S: aws.String(table.Kind)},
+		":zero": {N: aws.String("0")},
+	}
+	missing := []string{"attribute_not_exists(Kind)"}
+	setClauses := []string{"Kind = if_not_exists(Kind, :kind)"}
+	for i, sortKey := range table.SortKeys {
+		name := fmt.Sprintf("#sort%d", i)
+		names[name] = aws.String(sortKey)
+		missing = append(missing, "attribute_not_exists("+name+")")
+		// Events since the scan may have set it
+		setClauses = append(setClauses, name+" = if_not_exists("+name+", :zero)")
+	}
+
+	migrated := 0
+	var updateErr error
+	err := db.ScanPages(&dynamodb.ScanInput{
+		TableName:                aws.String(tableName),
+		ProjectionExpression:     aws.String("#key"),
+		FilterExpression:         aws.String(strings.Join(missing, " OR ")),
+		ExpressionAttributeNames: names,
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			// Items deleted since the scan are not created again
+			_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName:                 aws.String(tableName),
+				Key:                       item,
+				UpdateExpression:          aws.String("SET " + strings.Join(setClauses, ", ")),
+				ConditionExpression:       aws.String("attribute_exists(#key)"),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			})
+			var conditionFailed *dynamodb.ConditionalCheckFailedException
+			if errors.As(err, &conditionFailed) {
+				continue
+			}
+			if err != nil {
+				updateErr = err
+				return false
+			}
+			migrated++
+		}
+		return true
+	})
+	if err != nil {
+		return migrated, err
+	}
+	return migrated, updateErr
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/parquet-go/parquet-go"
+)
+
+// replaying is set when the consumer runs as the replay command, archiving,
+// alert rules and actor enrichment only run for live events
+var replaying bool
+
+// The aggregate tables a replay rebuilds, by the environment variable the
+// consumer reads their name from
+var replayTableVariables = []string{"EVENTS_COUNT_TABLE", "ACTORS_TABLE", "REPOS_TABLE", "LEADERBOARD_TABLE", "REPO_ACTIVITY_TABLE"}
+
+// liveActorTableName is the actors table of the live set, replays copy the
+// profiles the actorEnricher fetched from it
+var liveActorTableName string
+
+// Archived events are grouped by day
+var datePartition = regexp.MustCompile(`dt=(\d{4}-\d{2}-\d{2})`)
+
+// runReplay rebuilds the aggregate tables from the events archive, running
+// every archived event through handleEvent. Days are replayed in order, the
+// files of a day in the order the archive stream wrote them and the events
+// of a file in the order they happened.
+//
+//	githubEventsConsumer replay -tables tables.json [-state replay.state] [-reset] <file or directory>...
+//
+// tables.json maps the table environment variables to the tables to rebuild,
+// as exported by the replayTables stack output. The state file lists the
+// archive files already replayed, running the replay again on a newer copy
+// of the archive only replays the files added since.
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	tablesFile := flags.String("tables", "", "JSON file mapping the table environment variables to the tables to rebuild")
+	stateFile := flags.String("state", "replay.state", "file listing the archive files already replayed")
+	reset := flags.Bool("reset", false, "delete the items of the tables to rebuild first")
+	flags.Parse(args)
+
+	if *tablesFile == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("-tables and at least one input are required")
+	}
+	if err := loadReplayTables(*tablesFile); err != nil {
+		return err
+	}
+
+	replaying = true
+	if err := initDynamoDb(); err != nil {
+		return err
+	}
+
+	if *reset {
+		if err := os.Remove(*stateFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	replayed, err := readReplayState(*stateFile)
+	if err != nil {
+		return err
+	}
+
+	tables := []string{eventCountTableName, actorTableName, reposTableName, leaderboardTableName, repoActivityTableName}
+	for _, table := range tables {
+		if *reset {
+			fmt.Println("deleting the items of", table)
+			if err := clearTable(table); err != nil {
+				return err
+			}
+			continue
+		}
+		// The tables hold the files of the state
+		if len(replayed) > 0 {
+			continue
+		}
+		empty, err := isTableEmpty(table)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return fmt.Errorf("table %s is not empty, replay with -reset to rebuild it", table)
+		}
+	}
+
+	groups, err := replayGroups(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	state, err := os.OpenFile(*stateFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	total := 0
+	for _, group := range groups {
+		replayedGroup := 0
+		for _, path := range group.Files {
+			if replayed[replayStateKey(path)] {
+				continue
+			}
+			events, err := readArchiveFile(path)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			sort.SliceStable(events, func(i, j int) bool {
+				return events[i].CreatedAt.Before(events[j].CreatedAt)
+			})
+			for _, event := range events {
+				if err := handleEvent(logger, event); err != nil {
+					return fmt.Errorf("%s: event %s: %w", path, event.EventId, err)
+				}
+			}
+			// Checkpoint every file, a failure only replays the file it
+			// stopped in again
+			if _, err := fmt.Fprintln(state, replayStateKey(path)); err != nil {
+				return err
+			}
+			if err := state.Sync(); err != nil {
+				return err
+			}
+			replayedGroup += len(events)
+		}
+		if replayedGroup == 0 {
+			continue
+		}
+		total += replayedGroup
+		fmt.Println("replayed", replayedGroup, "events of", group.Key)
+	}
+	fmt.Println("replay done,", total, "events")
+
+	copied, err := copyActorProfiles()
+	if err != nil {
+		return err
+	}
+	fmt.Println("copied", copied, "actor profiles from", liveActorTableName)
+	return nil
+}
+
+// readReplayState returns the archive files the state file lists, none when
+// there is no state file yet
+func readReplayState(path string) (map[string]bool, error) {
+	replayed := map[string]bool{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return replayed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			replayed[line] = true
+		}
+	}
+	return replayed, nil
+}
+
+// replayStateKey identifies an archive file in the state, by its path in the
+// archive from the dt= partition on so copies of the archive downloaded to
+// another directory match
+func replayStateKey(path string) string {
+	path = filepath.ToSlash(path)
+	if index := strings.Index(path, "dt="); index >= 0 {
+		return path[index:]
+	}
+	return path
+}
+
+// The actor attributes the actorEnricher fills in
+var profileAttributes = []string{"ActorName", "Email", "Company", "Location", "Followers", "AccountType", "ProfileFetchedAt"}
+
+// copyActorProfiles copies the profiles of the live actors table onto the
+// replayed actors, replays queue no enrichment and the actorEnricher writes
+// to the live set. Returns the number of profiles copied
+func copyActorProfiles() (int, error) {
+	names := map[string]*string{}
+	var projection []string
+	for index, attribute := range append([]string{"Login", "BotReason"}, profileAttributes...) {
+		placeholder := fmt.Sprintf("#attribute%d", index)
+		names[placeholder] = aws.String(attribute)
+		projection = append(projection, placeholder)
+	}
+
+	copied := 0
+	var copyErr error
+	err := db.ScanPages(&dynamodb.ScanInput{
+		TableName:                aws.String(liveActorTableName),
+		ProjectionExpression:     aws.String(strings.Join(projection, ", ")),
+		FilterExpression:         aws.String("attribute_exists(ProfileFetchedAt)"),
+		ExpressionAttributeNames: names,
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			ok, err := copyActorProfile(item)
+			if err != nil {
+				copyErr = err
+				return false
+			}
+			if ok {
+				copied++
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return copied, err
+	}
+	return copied, copyErr
+}
+
+// copyActorProfile sets the profile of the live actor item on the replayed
+// actor, if the replay saw the actor
+func copyActorProfile(item map[string]*dynamodb.AttributeValue) (bool, error) {
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	var setClauses []string
+	for index, attribute := range profileAttributes {
+		value, ok := item[attribute]
+		if !ok {
+			continue
+		}
+		names[fmt.Sprintf("#attribute%d", index)] = aws.String(attribute)
+		values[fmt.Sprintf(":attribute%d", index)] = value
+		setClauses = append(setClauses, fmt.Sprintf("#attribute%d = :attribute%d", index, index))
+	}
+	// Like the actorEnricher, the account type overrides the heuristics
+	if aws.StringValue(item["BotReason"].S) == common.BotReasonAccountType {
+		setClauses = append(setClauses, "IsBot = :isBot", "BotReason = :botReason")
+		values[":isBot"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+		values[":botReason"] = item["BotReason"]
+	}
+
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(actorTableName),
+		Key:                       map[string]*dynamodb.AttributeValue{"Login": item["Login"]},
+		UpdateExpression:          aws.String("SET " + strings.Join(setClauses, ", ")),
+		ConditionExpression:       aws.String("attribute_exists(Login)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	var conditionFailed *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func loadReplayTables(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var tables map[string]string
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return fmt.Errorf("invalid tables file %s: %w", path, err)
+	}
+	for _, variable := range append(replayTableVariables, "LIVE_ACTORS_TABLE") {
+		if tables[variable] == "" {
+			return fmt.Errorf("tables file %s has no %s", path, variable)
+		}
+	}
+	if tables["LIVE_ACTORS_TABLE"] == tables["ACTORS_TABLE"] {
+		return fmt.Errorf("tables file %s rebuilds the live actors table", path)
+	}
+
+	eventCountTableName = tables["EVENTS_COUNT_TABLE"]
+	actorTableName = tables["ACTORS_TABLE"]
+	reposTableName = tables["REPOS_TABLE"]
+	leaderboardTableName = tables["LEADERBOARD_TABLE"]
+	repoActivityTableName = tables["REPO_ACTIVITY_TABLE"]
+	liveActorTableName = tables["LIVE_ACTORS_TABLE"]
+	return nil
+}
+
+// replayGroup are the archive files of a day partition, sorted by name, or a
+// single file outside of the partitioned layout
+type replayGroup struct {
+	Key   string
+	Files []string
+}
+
+func replayGroups(inputs []string) ([]replayGroup, error) {
+	byKey := map[string]*replayGroup{}
+	for _, input := range inputs {
+		err := filepath.WalkDir(input, func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				return nil
+			}
+			key := path
+			if match := datePartition.FindStringSubmatch(path); match != nil {
+				key = match[1]
+			}
+			if byKey[key] == nil {
+				byKey[key] = &replayGroup{Key: key}
+			}
+			byKey[key].Files = append(byKey[key].Files, path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var groups []replayGroup
+	for _, group := range byKey {
+		// The archive stream names its files after the time it wrote them
+		sort.Strings(group.Files)
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Key < groups[j].Key
+	})
+	return groups, nil
+}
+
+// readArchiveFile reads the events of a Parquet file written by the archive
+// stream, or of a JSON lines file (gzipped or not) of archive records, as
+// exported by an Athena UNLOAD
+func readArchiveFile(path string) ([]common.Github_event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case string(magic) == "PAR1":
+		return readParquet(file)
+	case magic[0] == 0x1f && magic[1] == 0x8b:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return readJSONLines(gz)
+	default:
+		return readJSONLines(file)
+	}
+}
+
+func readJSONLines(reader io.Reader) ([]common.Github_event, error) {
+	var events []common.Github_event
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var record archiveRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		createdAt, err := parseArchiveTime(record.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, record.event(createdAt))
+	}
+	return events, scanner.Err()
+}
+
+// parseArchiveTime parses the archive timestamps, Athena adds milliseconds
+// to the ones it exports
+func parseArchiveTime(value string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02 15:04:05.999999999", value)
+	if err != nil {
+		return time.Parse(time.RFC3339Nano, value)
+	}
+	return t, nil
+}
+
+func readParquet(file *os.File) ([]common.Github_event, error) {
+	reader := parquet.NewReader(file)
+	defer reader.Close()
+
+	columns := map[string]int{}
+	for index, path := range reader.Schema().Columns() {
+		columns[strings.Join(path, ".")] = index
+	}
+	createdAtColumn, ok := reader.Schema().Lookup("created_at")
+	if !ok {
+		return nil, errors.New("no created_at column")
+	}
+
+	var events []common.Github_event
+	rows := make([]parquet.Row, 100)
+	for {
+		n, err := reader.ReadRows(rows)
+		for _, row := range rows[:n] {
+			values := map[int]parquet.Value{}
+			for _, value := range row {
+				values[value.Column()] = value
+			}
+			column := func(name string) (parquet.Value, bool) {
+				index, ok := columns[name]
+				if !ok {
+					return parquet.Value{}, false
+				}
+				value, ok := values[index]
+				return value, ok && !value.IsNull()
+			}
+			text := func(name string) string {
+				if value, ok := column(name); ok {
+					return string(value.ByteArray())
+				}
+				return ""
+			}
+			flag := func(name string) bool {
+				value, ok := column(name)
+				return ok && value.Boolean()
+			}
+
+			record := archiveRecord{
+				ActorLogin: text("actor_login"),
+				ActorName:  text("actor_name"),
+				ActorEmail: text("actor_email"),
+				RepoName:   text("repo_name"),
+				RepoUrl:    text("repo_url"),
+				EventType:  text("event_type"),
+				Backfill:   flag("backfill"),
+			}
+			if value, ok := column("repo_id"); ok {
+				record.RepoId = value.Int64()
+			}
+			createdAt, _ := column("created_at")
+			events = append(events, record.event(parquetTime(createdAt, createdAtColumn.Node)))
+		}
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parquetTime reads a timestamp column, the Hive serializer Firehose converts
+// with writes INT96 timestamps
+func parquetTime(value parquet.Value, node parquet.Node) time.Time {
+	if value.IsNull() {
+		return time.Time{}
+	}
+	switch value.Kind() {
+	case parquet.Int96:
+		// Nanoseconds of the day followed by the Julian day
+		var raw [12]byte
+		int96 := value.Int96()
+		for i, word := range int96 {
+			binary.LittleEndian.PutUint32(raw[i*4:], word)
+		}
+		nanos := int64(binary.LittleEndian.Uint64(raw[:8]))
+		days := int64(binary.LittleEndian.Uint32(raw[8:])) - 2440588
+		return time.Unix(days*86400, nanos).UTC()
+	case parquet.Int64:
+		if logical := node.Type().LogicalType(); logical != nil && logical.Timestamp != nil {
+			switch {
+			case logical.Timestamp.Unit.Millis != nil:
+				return time.UnixMilli(value.Int64()).UTC()
+			case logical.Timestamp.Unit.Nanos != nil:
+				return time.Unix(0, value.Int64()).UTC()
+			}
+		}
+		return time.UnixMicro(value.Int64()).UTC()
+	}
+	return time.Time{}
+}
+
+func (r archiveRecord) event(createdAt time.Time) common.Github_event {
+	return common.Github_event{
+		ActorLogin: r.ActorLogin,
+		ActorName:  r.ActorName,
+		ActorEmail: r.ActorEmail,
+		RepoUrl:    r.RepoUrl,
+		RepoName:   r.RepoName,
+		RepoId:     r.RepoId,
+		EventType:  r.EventType,
+		CreatedAt:  createdAt,
+		Backfill:   r.Backfill,
+	}
+}
+
+func isTableEmpty(table string) (bool, error) {
+	result, err := db.Scan(&dynamodb.ScanInput{
+		TableName: aws.String(table),
+		Limit:     aws.Int64(1),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(result.Items) == 0, nil
+}
+
+// clearTable deletes every item of the table
+func clearTable(table string) error {
+	description, err := db.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for _, key := range description.Table.KeySchema {
+		keys = append(keys, aws.StringValue(key.AttributeName))
+	}
+
+	names := map[string]*string{}
+	var projection []string
+	for index, key := range keys {
+		placeholder := fmt.Sprintf("#key%d", index)
+		names[placeholder] = aws.String(key)
+		projection = append(projection, placeholder)
+	}
+
+	var requests []*dynamodb.WriteRequest
+	err = db.ScanPages(&dynamodb.ScanInput{
+		TableName:                aws.String(table),
+		ProjectionExpression:     aws.String(strings.Join(projection, ", ")),
+		ExpressionAttributeNames: names,
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			requests = append(requests, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{Key: item},
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// BatchWriteItem takes at most 25 requests
+	for start := 0; start < len(requests); start += 25 {
+		end := start + 25
+		if end > len(requests) {
+			end = len(requests)
+		}
+		pending := map[string][]*dynamodb.WriteRequest{table: requests[start:end]}
+		for len(pending) > 0 {
+			result, err := db.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = result.UnprocessedItems
+			if len(pending) > 0 {
+				time.Sleep(time.Second)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+var rulesTableName string
+
+// Topics and queues rules may deliver to, rules created before a target was
+// removed from the config are not delivered
+var ruleTargets common.RuleTargets
+
+var snsClient *sns.SNS
+var sqsClient *sqs.SQS
+
+// httpClient delivers the webhooks, only to public addresses and without
+// following redirects
+var httpClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: common.WebhookDialControl}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Webhooks are delivered in the background, at most maxWebhookDeliveries at
+// a time, the handler waits for them once the batch is consumed
+const maxWebhookDeliveries = 10
+
+var webhookDeliveries sync.WaitGroup
+var webhookSlots = make(chan struct{}, maxWebhookDeliveries)
+
+// Rules are reloaded at most once a minute, changes take up to that long to apply
+const rulesCacheTTL = time.Minute
+
+type alertRule struct {
+	RuleId     string
+	Name       string
+	Expression string
+	TargetType string
+	Target     string
+	rule       *common.Rule
+}
+
+// alertNotification is the message delivered to the targets of matching rules
+type alertNotification struct {
+	RuleId   string              `json:"ruleId"`
+	RuleName string              `json:"ruleName"`
+	Event    common.Github_event `json:"event"`
+}
+
+var rulesCache []alertRule
+var rulesLoadedAt time.Time
+
+// loadRules returns the alert rules, scanning the rules table when the cached
+// rules are older than rulesCacheTTL
+func loadRules(log *slog.Logger) ([]alertRule, error) {
+	if time.Since(rulesLoadedAt) < rulesCacheTTL {
+		return rulesCache, nil
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(rulesTableName),
+	}
+
+	rules := []alertRule{}
+	err := db.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			rule := alertRule{}
+			err := dynamodbattribute.UnmarshalMap(i, &rule)
+			if err != nil {
+				continue
+			}
+			// Rules are validated when created, skip the ones which no longer parse
+			rule.rule, err = common.ParseRule(rule.Expression)
+			if err != nil {
+				log.Warn("skipping invalid rule", "rule", rule.RuleId, "error", err)
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rulesCache = rules
+	rulesLoadedAt = time.Now()
+	return rules, nil
+}
+
+// evaluateRules delivers the event to the target of every rule it matches.
+// A failed delivery does not stop the other ones.
+func evaluateRules(log *slog.Logger, event common.Github_event) error {
+	rules, err := loadRules(log)
+	if err != nil {
+		log.Error("failed to load rules", "error", err)
+		return err
+	}
+
+	for _, rule := range rules {
+		if !rule.rule.Match(event) {
+			continue
+		}
+		log.Info("rule matched", "rule", rule.RuleId, "name", rule.Name)
+		if rule.TargetType == common.TargetWebhook {
+			deliverWebhookAsync(log, rule, event)
+			continue
+		}
+		err = deliverAlert(rule, event)
+		if err != nil {
+			log.Error("failed to deliver rule", "rule", rule.RuleId, "target", rule.TargetType, "error", err)
+		}
+	}
+	return nil
+}
+
+// deliverWebhookAsync delivers the alert to the webhook of the rule in the
+// background, so slow webhooks do not hold up the events after it
+func deliverWebhookAsync(log *slog.Logger, rule alertRule, event common.Github_event) {
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
+		webhookSlots <- struct{}{}
+		defer func() { <-webhookSlots }()
+		if err := deliverAlert(rule, event); err != nil {
+			log.Error("failed to deliver rule", "rule", rule.RuleId, "target", rule.TargetType, "error", err)
+		}
+	}()
+}
+
+// waitWebhookDeliveries waits for the webhooks delivered in the background,
+// Lambda freezes the ones still running once the handler returns
+func waitWebhookDeliveries() {
+	webhookDeliveries.Wait()
+}
+
+func deliverAlert(rule alertRule, event common.Github_event) error {
+	message, err := json.Marshal(alertNotification{
+		RuleId:   rule.RuleId,
+		RuleName: rule.Name,
+		Event:    event,
+	})
+	if err != nil {
+		return err
+	}
+
+	if (rule.TargetType == common.TargetSNS || rule.TargetType == common.TargetSQS) && !ruleTargets[rule.Target] {
+		return fmt.Errorf("%s is not an allowed rule target", rule.Target)
+	}
+
+	switch rule.TargetType {
+	case common.TargetSNS:
+		_, err = snsClient.Publish(&sns.PublishInput{
+			TopicArn: aws.String(rule.Target),
+			Subject:  aws.String("Rule matched: " + truncate(rule.Name, 80)),
+			Message:  aws.String(string(message)),
+		})
+		return err
+	case common.TargetSQS:
+		_, err = sqsClient.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:    aws.String(rule.Target),
+			MessageBody: aws.String(string(message)),
+		})
+		return err
+	case common.TargetWebhook:
+		// Rules created before the target checks were added are checked again
+		if err := common.ValidateWebhookTarget(rule.Target); err != nil {
+			return err
+		}
+		request, err := http.NewRequest(http.MethodPost, rule.Target, bytes.NewReader(message))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Rule-Id", rule.RuleId)
+		response, err := httpClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode >= 300 {
+			return fmt.Errorf("webhook responded with status %d", response.StatusCode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown target type %q", rule.TargetType)
+	}
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length]
+}
@@ -2,13 +2,12 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/ahmads/common"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/google/go-github/v55/github"
@@ -16,36 +15,48 @@ import (
 
 var githubEventsSqsUrl string
 
+var metrics = common.NewMetrics()
+
+var logger = common.NewLogger()
+
 func main() {
 
 	githubEventsSqsUrl = os.Getenv("GITHUB_CONSUMER_SQS_URL")
 	if githubEventsSqsUrl == "" {
-		fmt.Println("GITHUB_CONSUMER_SQS_URL is not set")
+		logger.Error("GITHUB_CONSUMER_SQS_URL is not set")
 		os.Exit(1)
 	}
-	fmt.Println("GITHUB_CONSUMER_SQS_URL is set to", githubEventsSqsUrl)
+	logger.Info("GITHUB_CONSUMER_SQS_URL is set", "value", githubEventsSqsUrl)
 
 	lambda.Start(handler)
 }
 
 func handler(ctx context.Context) error {
-	events, err := fetchEvents()
+	defer metrics.Flush()
+	// The events of a run share the request ID of the run as correlation ID
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		ctx = common.WithCorrelationId(ctx, lc.AwsRequestID)
+	}
+	log := common.RequestLogger(ctx, logger)
+
+	events, err := fetchEvents(ctx, log)
 	if err != nil {
-		fmt.Println("failed to fetch github events", err)
+		log.Error("failed to fetch github events", "error", err)
 		return err
 	}
-	err = sendEventsToConsumer(events)
+	metrics.Count("EventsFetched", float64(len(events)))
+	err = sendEventsToConsumer(ctx, log, events)
 	if err != nil {
-		fmt.Println("failed to send events to consumer", err)
+		log.Error("failed to send events to consumer", "error", err)
 		return err
 	}
 	return nil
 }
 
-func fetchEvents() ([]common.Github_event, error) {
-	fmt.Println("fetching github events")
+func fetchEvents(ctx context.Context, log *slog.Logger) ([]common.Github_event, error) {
+	log.Info("fetching github events")
 	client := github.NewClient(nil)
-	github_events, _, err := client.Activity.ListEvents(context.Background(), nil)
+	github_events, _, err := client.Activity.ListEvents(ctx, nil)
 
 	if err != nil {
 		return nil, err
@@ -53,7 +64,7 @@ func fetchEvents() ([]common.Github_event, error) {
 	var events []common.Github_event
 
 	for _, event := range github_events {
-		fmt.Println(event.Actor.GetLogin(), event.Repo.GetURL(), event.GetType(), event.Actor.GetEmail())
+		log.Debug("fetched github event", common.LogEventId, event.GetID(), "actor", event.Actor.GetLogin(), "repo", event.Repo.GetURL(), "type", event.GetType())
 
 		event.Repo.GetName()
 
@@ -65,39 +76,29 @@ func fetchEvents() ([]common.Github_event, error) {
 			RepoName:   event.Repo.GetName(),
 			RepoId:     event.Repo.GetID(),
 			EventType:  event.GetType(),
+			CreatedAt:  event.GetCreatedAt().Time,
+			EventId:    event.GetID(),
 		}
 		events = append(events, tmp)
 	}
 
-	fmt.Println("done fetching github events")
+	log.Info("done fetching github events", "events", len(events))
 	return events, nil
 }
 
-func sendEventsToConsumer(events []common.Github_event) error {
-	fmt.Println("sending events to consumer")
+func sendEventsToConsumer(ctx context.Context, log *slog.Logger, events []common.Github_event) error {
+	log.Info("sending events to consumer")
 	session := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
-	svc := sqs.New(session)
-
-	for _, event := range events {
-
-		jsonMessage, err := json.Marshal(event)
+	sink := common.NewSQSSink(sqs.New(session), githubEventsSqsUrl)
+	sink.Metrics = metrics
 
-		if err != nil {
-			return err
-		}
-
-		_, err = svc.SendMessage(&sqs.SendMessageInput{
-			MessageBody: aws.String(string(jsonMessage)),
-			QueueUrl:    &githubEventsSqsUrl,
-		})
-
-		if err != nil {
-			return err
-		}
+	err := sink.Send(ctx, events)
+	if err != nil {
+		return err
 	}
-	fmt.Println("done sending github events to consumer")
+	log.Info("done sending github events to consumer")
 
 	return nil
 }
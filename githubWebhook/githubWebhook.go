@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/google/go-github/v55/github"
+)
+
+var githubEventsSqsUrl string
+var webhookSecret []byte
+
+var sink common.EventSink
+
+var metrics = common.NewMetrics()
+
+var logger = common.NewLogger()
+
+// Most webhook payloads expose the sender and the repository through these
+// accessors, push payloads describe their repository with their own type
+type senderPayload interface {
+	GetSender() *github.User
+}
+
+type repoPayload interface {
+	GetRepo() *github.Repository
+}
+
+func main() {
+	githubEventsSqsUrl = os.Getenv("GITHUB_CONSUMER_SQS_URL")
+	if githubEventsSqsUrl == "" {
+		logger.Error("GITHUB_CONSUMER_SQS_URL is not set")
+		os.Exit(1)
+	}
+	logger.Info("GITHUB_CONSUMER_SQS_URL is set", "value", githubEventsSqsUrl)
+
+	secretParameter := os.Getenv("GITHUB_WEBHOOK_SECRET_PARAMETER")
+	if secretParameter == "" {
+		logger.Error("GITHUB_WEBHOOK_SECRET_PARAMETER is not set")
+		os.Exit(1)
+	}
+	logger.Info("GITHUB_WEBHOOK_SECRET_PARAMETER is set", "value", secretParameter)
+
+	sess := session.Must(session.NewSession())
+	secret, err := common.ReadSecret(sess, secretParameter)
+	if err != nil || secret == "" {
+		logger.Error("failed to read the webhook secret", "error", err)
+		os.Exit(1)
+	}
+	webhookSecret = []byte(secret)
+
+	sqsSink := common.NewSQSSink(sqs.New(sess), githubEventsSqsUrl)
+	sqsSink.Metrics = metrics
+	sink = sqsSink
+
+	lambda.Start(handler)
+}
+
+func handler(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	defer metrics.Flush()
+	// API Gateway lower cases the header names of HTTP APIs
+	deliveryId := request.Headers[strings.ToLower(github.DeliveryIDHeader)]
+	messageType := request.Headers[strings.ToLower(github.EventTypeHeader)]
+	signature := request.Headers[strings.ToLower(github.SHA256SignatureHeader)]
+
+	// The delivery ID traces the event through the pipeline
+	ctx = common.WithCorrelationId(ctx, deliveryId)
+	log := common.RequestLogger(ctx, logger).With(common.LogEventId, deliveryId, "messageType", messageType)
+
+	body := []byte(request.Body)
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(request.Body)
+		if err != nil {
+			return respond(http.StatusBadRequest, "invalid body"), nil
+		}
+		body = decoded
+	}
+
+	if signature == "" {
+		return respond(http.StatusUnauthorized, "missing signature"), nil
+	}
+	if err := github.ValidateSignature(signature, body, webhookSecret); err != nil {
+		log.Warn("rejected delivery", "error", err)
+		return respond(http.StatusUnauthorized, "invalid signature"), nil
+	}
+
+	if messageType == "ping" {
+		return respond(http.StatusOK, "pong"), nil
+	}
+
+	payload, err := github.ParseWebHook(messageType, body)
+	if err != nil {
+		log.Warn("failed to parse delivery", "error", err)
+		return respond(http.StatusBadRequest, "unsupported event"), nil
+	}
+
+	event, private, ok := toGithubEvent(messageType, payload)
+	if !ok {
+		// Events outside of a repository (organization, sponsorship...) are
+		// not tracked by the pipeline
+		log.Info("ignoring delivery")
+		return respond(http.StatusAccepted, "ignored"), nil
+	}
+	if !private {
+		// The fetcher polls the events of public repos, queueing their
+		// deliveries too would count them twice
+		log.Info("ignoring delivery of a public repo")
+		return respond(http.StatusAccepted, "ignored"), nil
+	}
+
+	event.EventId = deliveryId
+	err = sink.Send(ctx, []common.Github_event{event})
+	if err != nil {
+		log.Error("failed to send event to consumer", "error", err)
+		return respond(http.StatusInternalServerError, "failed to queue event"), nil
+	}
+	log.Info("queued delivery")
+	return respond(http.StatusAccepted, "queued"), nil
+}
+
+// Repos are keyed by the URL of the events API, webhook payloads carry the
+// HTML URL of push repos so the URL is built from the full name
+const repoApiUrl = "https://api.github.com/repos/"
+
+// toGithubEvent maps a parsed webhook payload of the X-GitHub-Event message
+// type onto the event the fetcher builds from the public events API and
+// tells whether its repo is private
+func toGithubEvent(messageType string, payload interface{}) (event common.Github_event, private bool, ok bool) {
+	event = common.Github_event{
+		EventType: eventType(messageType),
+		CreatedAt: time.Now(),
+	}
+
+	if p, ok := payload.(senderPayload); ok {
+		sender := p.GetSender()
+		event.ActorLogin = sender.GetLogin()
+		event.ActorName = sender.GetName()
+		event.ActorEmail = sender.GetEmail()
+	}
+
+	switch p := payload.(type) {
+	case *github.PushEvent:
+		event.RepoName = p.GetRepo().GetFullName()
+		event.RepoId = p.GetRepo().GetID()
+		private = p.GetRepo().GetPrivate()
+	case repoPayload:
+		event.RepoName = p.GetRepo().GetFullName()
+		event.RepoId = p.GetRepo().GetID()
+		private = p.GetRepo().GetPrivate()
+	}
+	if event.RepoName != "" {
+		event.RepoUrl = repoApiUrl + event.RepoName
+	}
+
+	return event, private, event.ActorLogin != "" && event.RepoName != ""
+}
+
+// eventType names the events API type of a webhook message type, i.e.
+// push is a PushEvent and pull_request_review_comment a
+// PullRequestReviewCommentEvent
+func eventType(messageType string) string {
+	var name strings.Builder
+	for _, word := range strings.Split(messageType, "_") {
+		if word == "" {
+			continue
+		}
+		name.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	name.WriteString("Event")
+	return name.String()
+}
+
+func respond(statusCode int, message string) events.APIGatewayV2HTTPResponse {
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: statusCode,
+		Body:       message,
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/go-github/v55/github"
+)
+
+// recordingSink records the events sent instead of queueing them
+type recordingSink struct {
+	events []common.Github_event
+}
+
+func (s *recordingSink) Send(ctx context.Context, events []common.Github_event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+const privatePush = `{"ref":"refs/heads/main","repository":{"id":1,"full_name":"org/private","private":true},"sender":{"login":"octocat"}}`
+
+func sign(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHandlerSignature(t *testing.T) {
+	webhookSecret = []byte("secret")
+
+	tests := []struct {
+		name        string
+		messageType string
+		signature   string
+		body        string
+		base64      bool
+		status      int
+		queued      bool
+	}{
+		{"valid", "push", sign("secret", privatePush), privatePush, false, http.StatusAccepted, true},
+		{"valid base64 body", "push", sign("secret", privatePush), base64.StdEncoding.EncodeToString([]byte(privatePush)), true, http.StatusAccepted, true},
+		{"ping", "ping", sign("secret", `{"zen":"Keep it logically awesome."}`), `{"zen":"Keep it logically awesome."}`, false, http.StatusOK, false},
+		{"missing", "push", "", privatePush, false, http.StatusUnauthorized, false},
+		{"malformed hex", "push", "sha256=not-hex", privatePush, false, http.StatusUnauthorized, false},
+		{"no algorithm", "push", sign("secret", privatePush)[len("sha256="):], privatePush, false, http.StatusUnauthorized, false},
+		{"unknown algorithm", "push", "md5=" + sign("secret", privatePush)[len("sha256="):], privatePush, false, http.StatusUnauthorized, false},
+		{"wrong secret", "push", sign("other", privatePush), privatePush, false, http.StatusUnauthorized, false},
+		{"tampered body", "push", sign("secret", privatePush), privatePush + " ", false, http.StatusUnauthorized, false},
+		{"invalid base64 body", "push", sign("secret", privatePush), "%%%", true, http.StatusBadRequest, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorded := &recordingSink{}
+			sink = recorded
+			request := events.APIGatewayV2HTTPRequest{
+				Headers: map[string]string{
+					"x-github-delivery": "delivery-1",
+					"x-github-event":    test.messageType,
+				},
+				Body:            test.body,
+				IsBase64Encoded: test.base64,
+			}
+			if test.signature != "" {
+				request.Headers["x-hub-signature-256"] = test.signature
+			}
+
+			response, err := handler(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status {
+				t.Errorf("status = %d (%s), want %d", response.StatusCode, response.Body, test.status)
+			}
+			if queued := len(recorded.events) == 1; queued != test.queued {
+				t.Errorf("queued = %v", queued)
+			}
+			if test.queued && recorded.events[0].EventId != "delivery-1" {
+				t.Errorf("event ID = %s", recorded.events[0].EventId)
+			}
+		})
+	}
+}
+
+func TestToGithubEvent(t *testing.T) {
+	tests := []struct {
+		name, messageType, body string
+		want                    common.Github_event
+		private, ok             bool
+	}{
+		{"push", "push", privatePush,
+			common.Github_event{EventType: "PushEvent", ActorLogin: "octocat", RepoName: "org/private", RepoId: 1, RepoUrl: "https://api.github.com/repos/org/private"}, true, true},
+		{"watch of a public repo", "watch", `{"action":"started","repository":{"id":2,"full_name":"org/public","private":false},"sender":{"login":"gopher"}}`,
+			common.Github_event{EventType: "WatchEvent", ActorLogin: "gopher", RepoName: "org/public", RepoId: 2, RepoUrl: "https://api.github.com/repos/org/public"}, false, true},
+		{"multi word type", "pull_request_review_comment", `{"action":"created","repository":{"id":3,"full_name":"org/private","private":true},"sender":{"login":"octocat"}}`,
+			common.Github_event{EventType: "PullRequestReviewCommentEvent", ActorLogin: "octocat", RepoName: "org/private", RepoId: 3, RepoUrl: "https://api.github.com/repos/org/private"}, true, true},
+		{"issue comment", "issue_comment", `{"action":"created","repository":{"id":4,"full_name":"org/private","private":true},"sender":{"login":"octocat","name":"The Octocat","email":"octocat@github.com"}}`,
+			common.Github_event{EventType: "IssueCommentEvent", ActorLogin: "octocat", ActorName: "The Octocat", ActorEmail: "octocat@github.com", RepoName: "org/private", RepoId: 4, RepoUrl: "https://api.github.com/repos/org/private"}, true, true},
+		{"outside of a repository", "organization", `{"action":"member_added","organization":{"login":"org"},"sender":{"login":"octocat"}}`,
+			common.Github_event{EventType: "OrganizationEvent", ActorLogin: "octocat"}, false, false},
+		{"without a sender", "push", `{"ref":"refs/heads/main","repository":{"id":1,"full_name":"org/private","private":true}}`,
+			common.Github_event{EventType: "PushEvent", RepoName: "org/private", RepoId: 1, RepoUrl: "https://api.github.com/repos/org/private"}, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := github.ParseWebHook(test.messageType, []byte(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			event, private, ok := toGithubEvent(test.messageType, payload)
+			if event.CreatedAt.IsZero() {
+				t.Error("the event has no time")
+			}
+			event.CreatedAt = test.want.CreatedAt
+			if event != test.want {
+				t.Errorf("event = %+v, want %+v", event, test.want)
+			}
+			if private != test.private || ok != test.ok {
+				t.Errorf("private, ok = %v, %v, want %v, %v", private, ok, test.private, test.ok)
+			}
+		})
+	}
+}
@@ -1,12 +1,14 @@
 module pointfive_pulumi
 
-go 1.18
+go 1.21.1
 
 require github.com/pulumi/pulumi/sdk/v3 v3.78.1
 
 require (
 	github.com/google/go-querystring v1.1.0 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
+	github.com/spf13/cast v1.4.1 // indirect
 )
 
 require (
@@ -75,3 +77,7 @@ require (
 	lukechampine.com/frand v1.4.2 // indirect
 	sourcegraph.com/sourcegraph/appdash v0.0.0-20211028080628-e2786a622600 // indirect
 )
+
+require github.com/ahmads/common v0.0.0
+
+replace github.com/ahmads/common => ./common
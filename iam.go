@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/dynamodb"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/kinesis"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/sns"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/sqs"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Managed policy letting lambdas write their logs
+const lambdaBasicExecutionPolicyArn = "arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"
+
+// policyStatement allows the actions on the resources
+type policyStatement struct {
+	Actions   []string
+	Resources pulumi.StringArray
+}
+
+// policyDocument renders the statements once their resource ARNs are known
+func policyDocument(statements []policyStatement) pulumi.StringOutput {
+	var resources []interface{}
+	for _, statement := range statements {
+		for _, resource := range statement.Resources {
+			resources = append(resources, resource)
+		}
+	}
+
+	return pulumi.All(resources...).ApplyT(func(arns []interface{}) (string, error) {
+		var rendered []map[string]interface{}
+		next := 0
+		for _, statement := range statements {
+			rendered = append(rendered, map[string]interface{}{
+				"Effect":   "Allow",
+				"Action":   statement.Actions,
+				"Resource": arns[next : next+len(statement.Resources)],
+			})
+			next += len(statement.Resources)
+		}
+
+		document, err := json.Marshal(map[string]interface{}{
+			"Version":   "2012-10-17",
+			"Statement": rendered,
+		})
+		return string(document), err
+	}).(pulumi.StringOutput)
+}
+
+// newServiceRole creates a role the service assumes, allowed nothing but the
+// statements
+func newServiceRole(ctx *pulumi.Context, name string, service string, statements []policyStatement, opts ...pulumi.ResourceOption) (*iam.Role, error) {
+	return newRole(ctx, name, service, nil, statements, opts...)
+}
+
+// newLambdaRole creates the execution role of a lambda, allowed to write its
+// logs and the statements
+func newLambdaRole(ctx *pulumi.Context, name string, statements []policyStatement, opts ...pulumi.ResourceOption) (*iam.Role, error) {
+	return newRole(ctx, name, "lambda.amazonaws.com", pulumi.StringArray{pulumi.String(lambdaBasicExecutionPolicyArn)}, statements, opts...)
+}
+
+// newRole embeds the policies in the role so they are in place as soon as
+// the resources using the role are created
+func newRole(ctx *pulumi.Context, name string, service string, managedPolicies pulumi.StringArray, statements []policyStatement, opts ...pulumi.ResourceOption) (*iam.Role, error) {
+	assumeRolePolicy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Action":    "sts:AssumeRole",
+			"Effect":    "Allow",
+			"Principal": map[string]string{"Service": service},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	args := &iam.RoleArgs{
+		AssumeRolePolicy:  pulumi.String(assumeRolePolicy),
+		ManagedPolicyArns: managedPolicies,
+	}
+	if len(statements) > 0 {
+		args.InlinePolicies = iam.RoleInlinePolicyArray{
+			&iam.RoleInlinePolicyArgs{
+				Name:   pulumi.String(name + "Policy"),
+				Policy: policyDocument(statements),
+			},
+		}
+	}
+	return iam.NewRole(ctx, name, args, opts...)
+}
+
+// allowTables allows the DynamoDB actions on the tables and their indexes
+func allowTables(actions []string, tables ...*dynamodb.Table) policyStatement {
+	statement := policyStatement{}
+	for _, action := range actions {
+		statement.Actions = append(statement.Actions, "dynamodb:"+action)
+	}
+	for _, table := range tables {
+		statement.Resources = append(statement.Resources, table.Arn, pulumi.Sprintf("%s/index/*", table.Arn))
+	}
+	return statement
+}
+
+// allowQueueSend allows sending messages to the queues
+func allowQueueSend(queues ...*sqs.Queue) policyStatement {
+	statement := policyStatement{Actions: []string{"sqs:SendMessage"}}
+	for _, queue := range queues {
+		statement.Resources = append(statement.Resources, queue.Arn)
+	}
+	return statement
+}
+
+// allowQueueConsume allows an event source mapping to feed the lambda with
+// the messages of the queue
+func allowQueueConsume(queue *sqs.Queue) policyStatement {
+	return policyStatement{
+		Actions: []string{
+			"sqs:ReceiveMessage",
+			"sqs:DeleteMessage",
+			"sqs:ChangeMessageVisibility",
+			"sqs:GetQueueAttributes",
+		},
+		Resources: pulumi.StringArray{queue.Arn},
+	}
+}
+
+// allowTopicPublish allows publishing to the topics
+func allowTopicPublish(topics ...*sns.Topic) policyStatement {
+	statement := policyStatement{Actions: []string{"sns:Publish"}}
+	for _, topic := range topics {
+		statement.Resources = append(statement.Resources, topic.Arn)
+	}
+	return statement
+}
+
+// allowStreamPut allows writing records to the Firehose stream
+func allowStreamPut(stream *kinesis.FirehoseDeliveryStream) policyStatement {
+	return policyStatement{
+		Actions:   []string{"firehose:PutRecord", "firehose:PutRecordBatch"},
+		Resources: pulumi.StringArray{stream.Arn},
+	}
+}
+
+// allowParameterRead allows reading the SSM parameters, decrypted with the
+// AWS managed key
+func allowParameterRead(parameters ...*ssm.Parameter) policyStatement {
+	statement := policyStatement{Actions: []string{"ssm:GetParameter"}}
+	for _, parameter := range parameters {
+		statement.Resources = append(statement.Resources, parameter.Arn)
+	}
+	return statement
+}
+
+// allowFunctionInvoke allows invoking the lambda
+func allowFunctionInvoke(function *lambda.Function) policyStatement {
+	return policyStatement{
+		Actions:   []string{"lambda:InvokeFunction"},
+		Resources: pulumi.StringArray{function.Arn},
+	}
+}
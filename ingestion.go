@@ -0,0 +1,166 @@
+package main
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/apigatewayv2"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/sqs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type EventIngestionArgs struct {
+	// EventBridge schedule expression of the fetcher
+	Schedule pulumi.StringInput
+	// Shared secret signing the webhook deliveries
+	WebhookSecret pulumi.StringInput
+	// Seconds a consumed event stays invisible, at least the consumer timeout
+	QueueVisibilityTimeout int
+	Fetcher                lambdaSettings
+	Webhook                lambdaSettings
+}
+
+// EventIngestion polls the public events and receives the webhook deliveries,
+// queueing both for the consumer
+type EventIngestion struct {
+	pulumi.ResourceState
+	component
+
+	Queue *sqs.Queue
+	// Events the consumer failed to process
+	DeadLetters *sqs.Queue
+	Fetcher     *lambda.Function
+	Webhook     *lambda.Function
+	WebhookUrl  pulumi.StringOutput
+}
+
+func NewEventIngestion(ctx *pulumi.Context, name string, args *EventIngestionArgs, opts ...pulumi.ResourceOption) (*EventIngestion, error) {
+	c := &EventIngestion{}
+	c.component = component{name: name, resource: c}
+	err := ctx.RegisterComponentResource("pointfive:pipeline:EventIngestion", name, c, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create SQS github_event_consumer_sqs
+	c.Queue, c.DeadLetters, err = c.newQueue(ctx, "githubConsumerSQS", 5, &sqs.QueueArgs{
+		VisibilityTimeoutSeconds: pulumi.Int(args.QueueVisibilityTimeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fetcherRole, err := c.newLambdaRole(ctx, "githubEventsFetcherRole", allowQueueSend(c.Queue))
+	if err != nil {
+		return nil, err
+	}
+
+	// Create fetcher Lambda
+	c.Fetcher, err = c.newFunction(ctx, "githubEventsFetcher", args.Fetcher, &lambda.FunctionArgs{
+		Role: fetcherRole.Arn,
+		Environment: &lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap{
+				"GITHUB_CONSUMER_SQS_URL": c.Queue.Url,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.newSchedule(ctx, "fetchSchedule", args.Schedule, c.Fetcher, "githubEventsFetcher", c.renamedFrom("everyTenMinutes")...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.newWebhook(ctx, args.WebhookSecret, args.Webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(c, pulumi.Map{
+		"queueUrl":   c.Queue.Url,
+		"fetcher":    c.Fetcher.Arn,
+		"webhook":    c.Webhook.Arn,
+		"webhookUrl": c.WebhookUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newWebhook receives GitHub webhook deliveries behind an HTTP API and queues
+// them for the consumer next to the polled events
+func (c *EventIngestion) newWebhook(ctx *pulumi.Context, secret pulumi.StringInput, settings lambdaSettings) error {
+	secretParameter, err := c.newSecret(ctx, "githubWebhookSecret", secret)
+	if err != nil {
+		return err
+	}
+
+	webhookRole, err := c.newLambdaRole(ctx, "githubWebhookRole", allowQueueSend(c.Queue), allowParameterRead(secretParameter))
+	if err != nil {
+		return err
+	}
+
+	c.Webhook, err = c.newFunction(ctx, "githubWebhook", settings, &lambda.FunctionArgs{
+		Role: webhookRole.Arn,
+		Environment: &lambda.FunctionEnvironmentArgs{
+			Variables: pulumi.StringMap{
+				"GITHUB_CONSUMER_SQS_URL":         c.Queue.Url,
+				"GITHUB_WEBHOOK_SECRET_PARAMETER": secretParameter.Name,
+			},
+		},
+	}, pulumi.DependsOn([]pulumi.Resource{c.Queue, secretParameter}))
+	if err != nil {
+		return err
+	}
+
+	webhookApi, err := apigatewayv2.NewApi(ctx, c.resourceName("githubWebhookApi"), &apigatewayv2.ApiArgs{
+		ProtocolType: pulumi.String("HTTP"),
+	}, c.childOptions("githubWebhookApi")...)
+	if err != nil {
+		return err
+	}
+
+	webhookIntegration, err := apigatewayv2.NewIntegration(ctx, c.resourceName("githubWebhookIntegration"), &apigatewayv2.IntegrationArgs{
+		ApiId:                webhookApi.ID(),
+		IntegrationType:      pulumi.String("AWS_PROXY"),
+		IntegrationUri:       c.Webhook.InvokeArn,
+		PayloadFormatVersion: pulumi.String("2.0"),
+	}, c.childOptions("githubWebhookIntegration")...)
+	if err != nil {
+		return err
+	}
+
+	_, err = apigatewayv2.NewRoute(ctx, c.resourceName("githubWebhookRoute"), &apigatewayv2.RouteArgs{
+		ApiId:    webhookApi.ID(),
+		RouteKey: pulumi.String("POST /github"),
+		Target: webhookIntegration.ID().ApplyT(func(id string) string {
+			return "integrations/" + id
+		}).(pulumi.StringOutput),
+	}, c.childOptions("githubWebhookRoute")...)
+	if err != nil {
+		return err
+	}
+
+	_, err = apigatewayv2.NewStage(ctx, c.resourceName("githubWebhookStage"), &apigatewayv2.StageArgs{
+		ApiId:      webhookApi.ID(),
+		Name:       pulumi.String("$default"),
+		AutoDeploy: pulumi.Bool(true),
+	}, c.childOptions("githubWebhookStage")...)
+	if err != nil {
+		return err
+	}
+
+	_, err = lambda.NewPermission(ctx, c.resourceName("allowTriggerGithubWebhookLambda"), &lambda.PermissionArgs{
+		Action:    pulumi.String("lambda:InvokeFunction"),
+		Function:  c.Webhook.Name,
+		Principal: pulumi.String("apigateway.amazonaws.com"),
+		SourceArn: pulumi.Sprintf("%s/*/*", webhookApi.ExecutionArn),
+	}, c.childOptions("allowTriggerGithubWebhookLambda", pulumi.DependsOn([]pulumi.Resource{c.Webhook, webhookApi}))...)
+	if err != nil {
+		return err
+	}
+
+	c.WebhookUrl = pulumi.Sprintf("%s/github", webhookApi.ApiEndpoint)
+	return nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ahmads/common"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/appsync"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/sns"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/sqs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type EventMonitoringArgs struct {
+	Fetcher  *lambda.Function
+	Consumer *lambda.Function
+	Resolver *lambda.Function
+	// Queue of the consumer and the dead-letter queues of the pipeline
+	Queue                 *sqs.Queue
+	ConsumerDeadLetters   *sqs.Queue
+	EnrichmentDeadLetters *sqs.Queue
+	Api                   *appsync.GraphQLApi
+	// Optional address subscribed to the alarms
+	AlarmEmail string
+}
+
+// EventMonitoring alarms on the failures of the pipeline and graphs its
+// throughput on the operations dashboard
+type EventMonitoring struct {
+	pulumi.ResourceState
+	component
+
+	// Topic notified when an alarm fires or recovers
+	Topic     *sns.Topic
+	Alarms    []*cloudwatch.MetricAlarm
+	Dashboard *cloudwatch.Dashboard
+}
+
+// Oldest message age of the consumer queue alarmed on, three fetches behind
+const queueAgeThreshold = 30 * 60
+
+func NewEventMonitoring(ctx *pulumi.Context, name string, args *EventMonitoringArgs, opts ...pulumi.ResourceOption) (*EventMonitoring, error) {
+	c := &EventMonitoring{}
+	c.component = component{name: name, resource: c}
+	err := ctx.RegisterComponentResource("pointfive:pipeline:EventMonitoring", name, c, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Topic, err = sns.NewTopic(ctx, c.resourceName("operationsTopic"), &sns.TopicArgs{}, c.childOptions("operationsTopic")...)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.AlarmEmail != "" {
+		_, err = sns.NewTopicSubscription(ctx, c.resourceName("operationsEmail"), &sns.TopicSubscriptionArgs{
+			Topic:    c.Topic.Arn,
+			Protocol: pulumi.String("email"),
+			Endpoint: pulumi.String(args.AlarmEmail),
+		}, c.childOptions("operationsEmail")...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	alarms := []struct {
+		base, description string
+		args              *cloudwatch.MetricAlarmArgs
+	}{
+		{"fetcherErrorsAlarm", "The fetcher failed to fetch or queue the GitHub events", &cloudwatch.MetricAlarmArgs{
+			Namespace:  pulumi.String("AWS/Lambda"),
+			MetricName: pulumi.String("Errors"),
+			Dimensions: pulumi.StringMap{"FunctionName": args.Fetcher.Name},
+			Statistic:  pulumi.String("Sum"),
+		}},
+		{"consumerErrorsAlarm", "The consumer failed, timed out or crashed processing a batch of events, SQS retries it", &cloudwatch.MetricAlarmArgs{
+			Namespace:  pulumi.String("AWS/Lambda"),
+			MetricName: pulumi.String("Errors"),
+			Dimensions: pulumi.StringMap{"FunctionName": args.Consumer.Name},
+			Statistic:  pulumi.String("Sum"),
+		}},
+		// Events the consumer fails to save are batch item failures of a
+		// successful invocation, Errors does not count them
+		{"consumerWriteFailuresAlarm", "The consumer failed to save events to the tables, SQS retries them", &cloudwatch.MetricAlarmArgs{
+			Namespace:  pulumi.String(common.MetricsNamespace),
+			MetricName: pulumi.String("EventWriteFailures"),
+			Dimensions: pulumi.StringMap{"FunctionName": args.Consumer.Name},
+			Statistic:  pulumi.String("Sum"),
+		}},
+		{"consumerThrottlesAlarm", "Invocations of the consumer are throttled", &cloudwatch.MetricAlarmArgs{
+			Namespace:  pulumi.String("AWS/Lambda"),
+			MetricName: pulumi.String("Throttles"),
+			Dimensions: pulumi.StringMap{"FunctionName": args.Consumer.Name},
+			Statistic:  pulumi.String("Sum"),
+		}},
+		{"consumerQueueAgeAlarm", "Events wait in the consumer queue for more than 30 minutes", &cloudwatch.MetricAlarmArgs{
+			Namespace:         pulumi.String("AWS/SQS"),
+			MetricName:        pulumi.String("ApproximateAgeOfOldestMessage"),
+			Dimensions:        pulumi.StringMap{"QueueName": args.Queue.Name},
+			Statistic:         pulumi.String("Maximum"),
+			Threshold:         pulumi.Float64(queueAgeThreshold),
+			EvaluationPeriods: pulumi.Int(2),
+		}},
+		{"consumerDeadLettersAlarm", "Events the consumer failed to process were moved to its dead-letter queue", &cloudwatch.MetricAlarmArgs{
+			Namespace:  pulumi.String("AWS/SQS"),
+			MetricName: pulumi.String("ApproximateNumberOfMessagesVisible"),
+			Dimensions: pulumi.StringMap{"QueueName": args.ConsumerDeadLetters.Name},
+			Statistic:  pulumi.String("Maximum"),
+		}},
+		{"enrichmentDeadLettersAlarm", "Logins the actor enricher failed to fetch were moved to its dead-letter queue", &cloudwatch.MetricAlarmArgs{
+			Namespace:  pulumi.String("AWS/SQS"),
+			MetricName: pulumi.String("ApproximateNumberOfMessagesVisible"),
+			Dimensions: pulumi.StringMap{"QueueName": args.EnrichmentDeadLetters.Name},
+			Statistic:  pulumi.String("Maximum"),
+		}},
+		{"apiErrorsAlarm", "The API answered requests with server errors", &cloudwatch.MetricAlarmArgs{
+			Namespace:  pulumi.String("AWS/AppSync"),
+			MetricName: pulumi.String("5XXError"),
+			Dimensions: pulumi.StringMap{"GraphQLAPIId": args.Api.ID().ToStringOutput()},
+			Statistic:  pulumi.String("Sum"),
+		}},
+	}
+	for _, alarm := range alarms {
+		created, err := c.newAlarm(ctx, alarm.base, alarm.description, alarm.args)
+		if err != nil {
+			return nil, err
+		}
+		c.Alarms = append(c.Alarms, created)
+	}
+
+	region, err := aws.GetRegion(ctx, nil, pulumi.Parent(c))
+	if err != nil {
+		return nil, err
+	}
+	var alarmArns []interface{}
+	for _, alarm := range c.Alarms {
+		alarmArns = append(alarmArns, alarm.Arn)
+	}
+	body := pulumi.All(args.Fetcher.Name, args.Consumer.Name, args.Resolver.Name, args.Queue.Name, args.ConsumerDeadLetters.Name,
+		args.EnrichmentDeadLetters.Name, args.Api.ID(), pulumi.All(alarmArns...)).ApplyT(func(values []interface{}) (string, error) {
+		return dashboardBody(region.Name, dashboardResources{
+			Fetcher:               values[0].(string),
+			Consumer:              values[1].(string),
+			Resolver:              values[2].(string),
+			Queue:                 values[3].(string),
+			ConsumerDeadLetters:   values[4].(string),
+			EnrichmentDeadLetters: values[5].(string),
+			Api:                   string(values[6].(pulumi.ID)),
+			Alarms:                values[7].([]interface{}),
+		})
+	}).(pulumi.StringOutput)
+
+	c.Dashboard, err = cloudwatch.NewDashboard(ctx, c.resourceName("operationsDashboard"), &cloudwatch.DashboardArgs{
+		DashboardName: pulumi.String(fmt.Sprintf("%s-%s-%s", ctx.Project(), ctx.Stack(), name)),
+		DashboardBody: body,
+	}, c.childOptions("operationsDashboard")...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(c, pulumi.Map{
+		"topicArn":  c.Topic.Arn,
+		"dashboard": c.Dashboard.DashboardName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newAlarm notifies the topic when the metric reaches the threshold, by
+// default any datapoint above zero in a five minutes period. Missing
+// datapoints, a lambda not invoked or an empty queue, are not breaching
+func (c *EventMonitoring) newAlarm(ctx *pulumi.Context, base string, description string, args *cloudwatch.MetricAlarmArgs) (*cloudwatch.MetricAlarm, error) {
+	args.AlarmDescription = pulumi.String(description)
+	args.AlarmActions = pulumi.Array{c.Topic.Arn}
+	args.OkActions = pulumi.Array{c.Topic.Arn}
+	args.ComparisonOperator = pulumi.String("GreaterThanOrEqualToThreshold")
+	args.TreatMissingData = pulumi.String("notBreaching")
+	args.Period = pulumi.Int(300)
+	if args.Threshold == nil {
+		args.Threshold = pulumi.Float64(1)
+	}
+	if args.EvaluationPeriods == nil {
+		args.EvaluationPeriods = pulumi.Int(1)
+	}
+	return cloudwatch.NewMetricAlarm(ctx, c.resourceName(base), args, c.childOptions(base)...)
+}
+
+// search graphs the metric of the lambda by its other dimension, one line per
+// value of the dimension. The id names the expression within its widget
+func search(id, dimension, metric, function, stat string) []interface{} {
+	expression := fmt.Sprintf(`SEARCH('{%s,FunctionName,%s} MetricName="%s" FunctionName="%s"', '%s', 300)`,
+		common.MetricsNamespace, dimension, metric, function, stat)
+	return []interface{}{map[string]interface{}{"expression": expression, "id": id}}
+}
+
+// dashboardResources are the names of the resources graphed on the dashboard
+type dashboardResources struct {
+	Fetcher               string
+	Consumer              string
+	Resolver              string
+	Queue                 string
+	ConsumerDeadLetters   string
+	EnrichmentDeadLetters string
+	Api                   string
+	Alarms                []interface{}
+}
+
+// dashboardBody renders the widgets of the operations dashboard, see
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/CloudWatch-Dashboard-Body-Structure.html
+func dashboardBody(region string, r dashboardResources) (string, error) {
+	metricWidget := func(title string, stat string, metrics ...[]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"type":   "metric",
+			"width":  12,
+			"height": 6,
+			"properties": map[string]interface{}{
+				"title":   title,
+				"region":  region,
+				"stat":    stat,
+				"period":  300,
+				"view":    "timeSeries",
+				"metrics": metrics,
+			},
+		}
+	}
+
+	widgets := []map[string]interface{}{
+		{
+			"type":   "alarm",
+			"width":  24,
+			"height": 3,
+			"properties": map[string]interface{}{
+				"title":  "Alarms",
+				"alarms": r.Alarms,
+			},
+		},
+		// The fetcher publishes one EventsFetched datapoint per run, the
+		// metrics of the lambdas are in the PointFive namespace, see
+		// common/metrics.go
+		metricWidget("Events ingested per fetcher run", "Sum",
+			[]interface{}{common.MetricsNamespace, "EventsFetched", "FunctionName", r.Fetcher, map[string]interface{}{"label": "Events per run", "stat": "Average"}},
+			[]interface{}{common.MetricsNamespace, "EventsSent", "FunctionName", r.Fetcher, map[string]interface{}{"label": "Events sent"}},
+			[]interface{}{common.MetricsNamespace, "SendFailures", "FunctionName", r.Fetcher, map[string]interface{}{"label": "Send failures"}},
+		),
+		metricWidget("Events consumed per type", "Sum",
+			search("types", "EventType", "EventsConsumed", r.Consumer, "Sum"),
+		),
+		metricWidget("DynamoDB latency p90 (ms)", "p90",
+			search("consumer", "Operation", "DynamoDBLatency", r.Consumer, "p90"),
+			search("resolver", "Operation", "DynamoDBLatency", r.Resolver, "p90"),
+		),
+		metricWidget("Resolver latency p90 per field (ms)", "p90",
+			search("fields", "Field", "ResolverLatency", r.Resolver, "p90"),
+		),
+		metricWidget("Lambda errors and throttles", "Sum",
+			[]interface{}{"AWS/Lambda", "Errors", "FunctionName", r.Fetcher, map[string]interface{}{"label": "Fetcher errors"}},
+			[]interface{}{"AWS/Lambda", "Errors", "FunctionName", r.Consumer, map[string]interface{}{"label": "Consumer errors"}},
+			[]interface{}{"AWS/Lambda", "Throttles", "FunctionName", r.Consumer, map[string]interface{}{"label": "Consumer throttles"}},
+		),
+		metricWidget("Consumer duration", "Average",
+			[]interface{}{"AWS/Lambda", "Duration", "FunctionName", r.Consumer, map[string]interface{}{"label": "Average"}},
+			[]interface{}{"AWS/Lambda", "Duration", "FunctionName", r.Consumer, map[string]interface{}{"label": "Maximum", "stat": "Maximum"}},
+		),
+		metricWidget("Queues", "Maximum",
+			[]interface{}{"AWS/SQS", "ApproximateAgeOfOldestMessage", "QueueName", r.Queue, map[string]interface{}{"label": "Oldest event (seconds)"}},
+			[]interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", r.ConsumerDeadLetters, map[string]interface{}{"label": "Consumer dead letters", "yAxis": "right"}},
+			[]interface{}{"AWS/SQS", "ApproximateNumberOfMessagesVisible", "QueueName", r.EnrichmentDeadLetters, map[string]interface{}{"label": "Enrichment dead letters", "yAxis": "right"}},
+		),
+		metricWidget("API", "Sum",
+			[]interface{}{"AWS/AppSync", "5XXError", "GraphQLAPIId", r.Api, map[string]interface{}{"label": "Server errors"}},
+			[]interface{}{"AWS/AppSync", "4XXError", "GraphQLAPIId", r.Api, map[string]interface{}{"label": "Client errors"}},
+			[]interface{}{"AWS/AppSync", "Latency", "GraphQLAPIId", r.Api, map[string]interface{}{"label": "Latency (ms)", "stat": "Average", "yAxis": "right"}},
+		),
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"widgets": widgets})
+	return string(body), err
+}
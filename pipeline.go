@@ -0,0 +1,152 @@
+package main
+
+import (
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type PipelineArgs struct {
+	// EventBridge schedule expression of the fetcher
+	FetchSchedule pulumi.StringInput
+	// Shared secret signing the webhook deliveries
+	WebhookSecret pulumi.StringInput
+	// Optional GitHub token raising the enrichers rate limit, nil without
+	GithubToken pulumi.StringInput
+	// Aggregate table set the consumer writes to, primary or shadow
+	LiveTables string
+	// Create the shadow table set even when it is not live
+	ShadowTables bool
+	// Stop consuming the queue while a replay catches up before a swap over
+	PauseConsumer bool
+	// Delete the archived events with the stack
+	ForceDestroyArchive bool
+	// RFC 3339 expiry of the API key
+	ApiKeyExpiry pulumi.StringInput
+	// Events passed to each consumer invocation
+	ConsumerBatchSize int
+	// Logins passed to each actorEnricher invocation
+	ActorEnricherBatchSize int
+	Tables                 tableSettings
+	// Settings of every lambda, by lambda name
+	Lambdas map[string]lambdaSettings
+	// Optional address subscribed to the alarms
+	AlarmEmail string
+	// SNS topics and SQS queues alert rules may deliver to
+	RuleTargets ruleTargets
+}
+
+// pipeline is a complete events pipeline, from the ingestion of the events
+// to the API serving their aggregates
+type pipeline struct {
+	Ingestion  *EventIngestion
+	Archive    *EventArchive
+	Processor  *EventProcessor
+	Enrichment *EventEnrichment
+	Analytics  *EventAnalytics
+	Query      *QueryApi
+	Monitoring *EventMonitoring
+}
+
+// newPipeline creates the components of a pipeline. Pipelines other than the
+// default one prefix the names of their resources with their name, so a stack
+// can deploy several of them
+func newPipeline(ctx *pulumi.Context, name string, args *PipelineArgs) (*pipeline, error) {
+	// Build the lambdas in parallel while the components are created
+	for base, settings := range args.Lambdas {
+		lambdaBuilder(lambdaModules[base], lambdaArchitectures[settings.Architecture])
+	}
+
+	ingestion, err := NewEventIngestion(ctx, name, &EventIngestionArgs{
+		Schedule:      args.FetchSchedule,
+		WebhookSecret: args.WebhookSecret,
+		// Six times the consumer timeout leaves room for the retries of the
+		// consumer within a poll, as AWS recommends
+		QueueVisibilityTimeout: 6 * args.Lambdas["githubEventsConsumer"].Timeout,
+		Fetcher:                args.Lambdas["githubEventsFetcher"],
+		Webhook:                args.Lambdas["githubWebhook"],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := NewEventArchive(ctx, name, &EventArchiveArgs{
+		ForceDestroy: args.ForceDestroyArchive,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	processor, err := NewEventProcessor(ctx, name, &EventProcessorArgs{
+		Queue:         ingestion.Queue,
+		ArchiveStream: archive.Stream,
+		LiveTables:    args.LiveTables,
+		ShadowTables:  args.ShadowTables,
+		PauseConsumer: args.PauseConsumer,
+		BatchSize:     args.ConsumerBatchSize,
+		TableSettings: args.Tables,
+		RuleTargets:   args.RuleTargets,
+		Consumer:      args.Lambdas["githubEventsConsumer"],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	enrichment, err := NewEventEnrichment(ctx, name, &EventEnrichmentArgs{
+		Actors:        processor.Tables.Actors,
+		Repos:         processor.Tables.Repos,
+		Queue:         processor.EnrichmentQueue,
+		GithubToken:   args.GithubToken,
+		BatchSize:     args.ActorEnricherBatchSize,
+		ActorEnricher: args.Lambdas["actorEnricher"],
+		RepoEnricher:  args.Lambdas["repoEnricher"],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	analytics, err := NewEventAnalytics(ctx, name, &EventAnalyticsArgs{
+		Leaderboard:        processor.Tables.Leaderboard,
+		RepoActivity:       processor.Tables.RepoActivity,
+		TableSettings:      args.Tables,
+		TrendingAggregator: args.Lambdas["trendingAggregator"],
+		AnomalyDetector:    args.Lambdas["anomalyDetector"],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := NewQueryApi(ctx, name, &QueryApiArgs{
+		Tables:        processor.Tables,
+		TrendingTable: analytics.TrendingTable,
+		RulesTable:    processor.RulesTable,
+		ApiKeyExpiry:  args.ApiKeyExpiry,
+		RuleTargets:   args.RuleTargets,
+		Resolver:      args.Lambdas["resolverLambdaFunction"],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	monitoring, err := NewEventMonitoring(ctx, name, &EventMonitoringArgs{
+		Fetcher:               ingestion.Fetcher,
+		Consumer:              processor.Consumer,
+		Resolver:              query.Resolver,
+		Queue:                 ingestion.Queue,
+		ConsumerDeadLetters:   ingestion.DeadLetters,
+		EnrichmentDeadLetters: processor.EnrichmentDeadLetters,
+		Api:                   query.Api,
+		AlarmEmail:            args.AlarmEmail,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pipeline{
+		Ingestion:  ingestion,
+		Archive:    archive,
+		Processor:  processor,
+		Enrichment: enrichment,
+		Analytics:  analytics,
+		Query:      query,
+		Monitoring: monitoring,
+	}, nil
+}
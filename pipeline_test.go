@@ -0,0 +1,804 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	functionType  = "aws:lambda/function:Function"
+	roleType      = "aws:iam/role:Role"
+	queueType     = "aws:sqs/queue:Queue"
+	tableType     = "aws:dynamodb/table:Table"
+	mappingType   = "aws:lambda/eventSourceMapping:EventSourceMapping"
+	ruleType      = "aws:cloudwatch/eventRule:EventRule"
+	targetType    = "aws:cloudwatch/eventTarget:EventTarget"
+	resolverType  = "aws:appsync/resolver:Resolver"
+	parameterType = "aws:ssm/parameter:Parameter"
+)
+
+func TestMain(m *testing.M) {
+	// The zips are not read by the mocks, skip building the modules
+	lambdaBuilder = func(module, goarch string) *lambdaBuild {
+		b := &lambdaBuild{done: make(chan struct{}), path: fmt.Sprintf("tmp/build/%s-%s.zip", module, goarch)}
+		close(b.done)
+		return b
+	}
+	os.Exit(m.Run())
+}
+
+// mocks records the resources of the program, completing their outputs with
+// ARNs, names and URLs derived from their resource names
+type mocks struct {
+	mu        sync.Mutex
+	resources map[string]resource.PropertyMap
+	// IDs of the imported resources
+	imports map[string]string
+}
+
+func (m *mocks) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	outputs := args.Inputs.Copy()
+	computed := map[string]string{
+		"arn":          mockArn(args.TypeToken, args.Name),
+		"name":         args.Name,
+		"url":          "https://sqs.us-east-1.amazonaws.com/123456789012/" + args.Name,
+		"invokeArn":    "arn:aws:apigateway:invoke:" + args.Name,
+		"executionArn": "arn:aws:execute-api:" + args.Name,
+		"apiEndpoint":  "https://" + args.Name + ".execute-api.amazonaws.com",
+		"bucket":       args.Name,
+	}
+	for key, value := range computed {
+		if _, ok := outputs[resource.PropertyKey(key)]; !ok {
+			outputs[resource.PropertyKey(key)] = resource.NewStringProperty(value)
+		}
+	}
+	if args.TypeToken == "aws:appsync/graphQLApi:GraphQLApi" {
+		outputs["uris"] = resource.NewObjectProperty(resource.PropertyMap{
+			"GRAPHQL": resource.NewStringProperty("https://" + args.Name + ".appsync-api.amazonaws.com/graphql"),
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resources[args.TypeToken+"::"+args.Name] = outputs
+	if args.ID != "" {
+		m.imports[args.TypeToken+"::"+args.Name] = args.ID
+	}
+	return args.Name + "_id", outputs, nil
+}
+
+func (m *mocks) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	return args.Args, nil
+}
+
+func mockArn(typeToken, name string) string {
+	return "arn:" + typeToken + ":" + name
+}
+
+// run runs the program under mocks with the stack config
+func run(t *testing.T, config map[string]string, program pulumi.RunFunc) (*mocks, error) {
+	t.Helper()
+	// Settings every stack sets, unless the test sets them
+	values := map[string]string{
+		"pointfive_pulumi:githubWebhookSecret": "secret",
+		"pointfive_pulumi:apiKeyExpiry":        time.Now().UTC().AddDate(0, 6, 0).Format(time.RFC3339),
+	}
+	for key, value := range config {
+		values["pointfive_pulumi:"+key] = value
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PULUMI_CONFIG", string(encoded))
+
+	m := &mocks{resources: map[string]resource.PropertyMap{}, imports: map[string]string{}}
+	err = pulumi.RunErr(program, pulumi.WithMocks("pointfive_pulumi", "test", m))
+	return m, err
+}
+
+// runPipelines runs the pipelines with the default settings
+func runPipelines(t *testing.T, names ...string) *mocks {
+	t.Helper()
+	return runPipelinesWith(t, nil, names...)
+}
+
+func runPipelinesWith(t *testing.T, config map[string]string, names ...string) *mocks {
+	t.Helper()
+	if config == nil {
+		config = map[string]string{}
+	}
+	config["githubWebhookSecret"] = "secret"
+	m, err := run(t, config, func(ctx *pulumi.Context) error {
+		args, err := loadPipelineArgs(ctx)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			_, err = newPipeline(ctx, name, args)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func (m *mocks) get(t *testing.T, typeToken, name string) resource.PropertyMap {
+	t.Helper()
+	props, ok := m.resources[typeToken+"::"+name]
+	if !ok {
+		t.Fatalf("no %s named %s", typeToken, name)
+	}
+	return props
+}
+
+func (m *mocks) names(typeToken string) []string {
+	var names []string
+	for key := range m.resources {
+		if name, ok := strings.CutPrefix(key, typeToken+"::"); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// str returns the string, secret or not, of the property
+func str(value resource.PropertyValue) string {
+	if value.IsSecret() {
+		value = value.SecretValue().Element
+	}
+	if !value.IsString() {
+		return ""
+	}
+	return value.StringValue()
+}
+
+func environment(t *testing.T, function resource.PropertyMap) map[string]string {
+	t.Helper()
+	variables := map[string]string{}
+	env := function["environment"]
+	if !env.IsObject() {
+		return variables
+	}
+	for key, value := range env.ObjectValue()["variables"].ObjectValue() {
+		variables[string(key)] = str(value)
+	}
+	return variables
+}
+
+// allowed lists the actions the inline policy of the role allows, as
+// "action resource" pairs
+func allowed(t *testing.T, role resource.PropertyMap) map[string]bool {
+	t.Helper()
+	pairs := map[string]bool{}
+	for _, policy := range role["inlinePolicies"].ArrayValue() {
+		var document struct {
+			Statement []struct {
+				Effect   string
+				Action   []string
+				Resource []string
+			}
+		}
+		err := json.Unmarshal([]byte(str(policy.ObjectValue()["policy"])), &document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, statement := range document.Statement {
+			if statement.Effect != "Allow" {
+				t.Fatalf("unexpected effect %s", statement.Effect)
+			}
+			for _, action := range statement.Action {
+				for _, resource := range statement.Resource {
+					pairs[action+" "+resource] = true
+				}
+			}
+		}
+	}
+	return pairs
+}
+
+func TestPipelineLambdas(t *testing.T) {
+	m := runPipelines(t, defaultPipeline)
+
+	modules := map[string]string{}
+	for name, module := range lambdaModules {
+		modules[name] = module
+	}
+	for _, name := range m.names(functionType) {
+		module, ok := modules[name]
+		if !ok {
+			t.Errorf("unexpected lambda %s", name)
+			continue
+		}
+		delete(modules, name)
+
+		function := m.get(t, functionType, name)
+		if got := str(function["runtime"]); got != "provided.al2023" {
+			t.Errorf("%s runtime = %s", name, got)
+		}
+		if got := str(function["handler"]); got != "bootstrap" {
+			t.Errorf("%s handler = %s", name, got)
+		}
+		if got := function["architectures"].ArrayValue(); len(got) != 1 || str(got[0]) != "arm64" {
+			t.Errorf("%s architectures = %v", name, got)
+		}
+		if got := function["code"].ArchiveValue().Path; got != "tmp/build/"+module+"-arm64.zip" {
+			t.Errorf("%s code = %s", name, got)
+		}
+		if got := str(function["role"]); got != mockArn(roleType, name+"Role") && !(name == "resolverLambdaFunction" && got == mockArn(roleType, "resolverRole")) {
+			t.Errorf("%s role = %s", name, got)
+		}
+
+		logs := m.get(t, "aws:cloudwatch/logGroup:LogGroup", name+"Logs")
+		if got := str(logs["name"]); got != "/aws/lambda/"+name {
+			t.Errorf("%s log group = %s", name, got)
+		}
+	}
+	for name := range modules {
+		t.Errorf("missing lambda %s", name)
+	}
+	if len(m.imports) != 0 {
+		t.Errorf("imported %v", m.imports)
+	}
+}
+
+func TestPipelineImportLogGroups(t *testing.T) {
+	m := runPipelinesWith(t, map[string]string{"importLogGroups": "true"}, defaultPipeline)
+
+	for _, name := range m.names(functionType) {
+		key := "aws:cloudwatch/logGroup:LogGroup::" + name + "Logs"
+		if got := m.imports[key]; got != "/aws/lambda/"+name {
+			t.Errorf("%s log group imported from %q", name, got)
+		}
+	}
+	if len(m.imports) != len(lambdaModules) {
+		t.Errorf("imported %v", m.imports)
+	}
+}
+
+func TestPipelineEnvironment(t *testing.T) {
+	m := runPipelines(t, defaultPipeline)
+
+	queueUrl := func(name string) string { return str(m.get(t, queueType, name)["url"]) }
+	table := func(name string) string { return str(m.get(t, tableType, name)["name"]) }
+	aggregates := map[string]string{
+		"ACTORS_TABLE":        table("actorsTable"),
+		"EVENTS_COUNT_TABLE":  table("EventsCounts"),
+		"REPOS_TABLE":         table("ReposTable"),
+		"LEADERBOARD_TABLE":   table("LeaderboardTable"),
+		"REPO_ACTIVITY_TABLE": table("RepoActivityTable"),
+	}
+	with := func(base map[string]string, extra map[string]string) map[string]string {
+		merged := map[string]string{}
+		for key, value := range base {
+			merged[key] = value
+		}
+		for key, value := range extra {
+			merged[key] = value
+		}
+		return merged
+	}
+
+	tests := map[string]map[string]string{
+		"githubEventsFetcher": {
+			"GITHUB_CONSUMER_SQS_URL": queueUrl("githubConsumerSQS"),
+		},
+		"githubWebhook": {
+			"GITHUB_CONSUMER_SQS_URL":         queueUrl("githubConsumerSQS"),
+			"GITHUB_WEBHOOK_SECRET_PARAMETER": str(m.get(t, parameterType, "githubWebhookSecret")["name"]),
+		},
+		"githubEventsConsumer": with(aggregates, map[string]string{
+			"RULES_TABLE":                table("RulesTable"),
+			"ACTOR_ENRICHMENT_QUEUE_URL": queueUrl("actorEnrichmentQueue"),
+			"ARCHIVE_STREAM_NAME":        str(m.get(t, "aws:kinesis/firehoseDeliveryStream:FirehoseDeliveryStream", "eventsArchiveStream")["name"]),
+		}),
+		"trendingAggregator": {
+			"LEADERBOARD_TABLE":   table("LeaderboardTable"),
+			"REPO_ACTIVITY_TABLE": table("RepoActivityTable"),
+			"TRENDING_TABLE":      table("TrendingTable"),
+		},
+		"anomalyDetector": {
+			"LEADERBOARD_TABLE":   table("LeaderboardTable"),
+			"REPO_ACTIVITY_TABLE": table("RepoActivityTable"),
+			"ANOMALIES_TABLE":     table("AnomaliesTable"),
+			"ALERTS_TOPIC_ARN":    mockArn("aws:sns/topic:Topic", "alertsTopic"),
+		},
+		"repoEnricher": {
+			"REPOS_TABLE": table("ReposTable"),
+		},
+		"actorEnricher": {
+			"ACTORS_TABLE": table("actorsTable"),
+		},
+		"resolverLambdaFunction": {
+			"ACTORS_TABLE":       table("actorsTable"),
+			"EVENTS_COUNT_TABLE": table("EventsCounts"),
+			"REPOS_TABLE":        table("ReposTable"),
+			"LEADERBOARD_TABLE":  table("LeaderboardTable"),
+			"TRENDING_TABLE":     table("TrendingTable"),
+			"RULES_TABLE":        table("RulesTable"),
+		},
+	}
+	for function, want := range tests {
+		want = with(want, map[string]string{"LOG_LEVEL": "info"})
+		t.Run(function, func(t *testing.T) {
+			got := environment(t, m.get(t, functionType, function))
+			for key, value := range want {
+				if got[key] != value {
+					t.Errorf("%s = %q, want %q", key, got[key], value)
+				}
+				if value == "" {
+					t.Errorf("%s is empty", key)
+				}
+			}
+			for key := range got {
+				if _, ok := want[key]; !ok {
+					t.Errorf("unexpected variable %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestPipelinePolicies(t *testing.T) {
+	m := runPipelines(t, defaultPipeline)
+
+	queue := func(name string) string { return mockArn(queueType, name) }
+	table := func(name string) string { return mockArn(tableType, name) }
+	tests := map[string][]string{
+		"githubEventsFetcherRole": {
+			"sqs:SendMessage " + queue("githubConsumerSQS"),
+		},
+		"githubWebhookRole": {
+			"sqs:SendMessage " + queue("githubConsumerSQS"),
+			"ssm:GetParameter " + mockArn(parameterType, "githubWebhookSecret"),
+		},
+		"githubEventsConsumerRole": {
+			"sqs:ReceiveMessage " + queue("githubConsumerSQS"),
+			"sqs:DeleteMessage " + queue("githubConsumerSQS"),
+			"dynamodb:UpdateItem " + table("actorsTable"),
+			"dynamodb:UpdateItem " + table("EventsCounts"),
+			"dynamodb:UpdateItem " + table("ReposTable"),
+			"dynamodb:UpdateItem " + table("LeaderboardTable"),
+			"dynamodb:UpdateItem " + table("RepoActivityTable"),
+			"dynamodb:Scan " + table("RulesTable"),
+			"sqs:SendMessage " + queue("actorEnrichmentQueue"),
+			"firehose:PutRecordBatch " + mockArn("aws:kinesis/firehoseDeliveryStream:FirehoseDeliveryStream", "eventsArchiveStream"),
+		},
+		"trendingAggregatorRole": {
+			"dynamodb:Query " + table("LeaderboardTable"),
+			"dynamodb:Query " + table("LeaderboardTable") + "/index/*",
+			"dynamodb:Query " + table("RepoActivityTable"),
+			"dynamodb:PutItem " + table("TrendingTable"),
+		},
+		"anomalyDetectorRole": {
+			"dynamodb:Query " + table("RepoActivityTable"),
+			"dynamodb:GetItem " + table("AnomaliesTable"),
+			"dynamodb:PutItem " + table("AnomaliesTable"),
+			"sns:Publish " + mockArn("aws:sns/topic:Topic", "alertsTopic"),
+		},
+		"repoEnricherRole": {
+			"dynamodb:Query " + table("ReposTable") + "/index/*",
+			"dynamodb:UpdateItem " + table("ReposTable"),
+		},
+		"actorEnricherRole": {
+			"sqs:ReceiveMessage " + queue("actorEnrichmentQueue"),
+			"dynamodb:GetItem " + table("actorsTable"),
+			"dynamodb:UpdateItem " + table("actorsTable"),
+		},
+		"resolverRole": {
+			"dynamodb:Query " + table("TrendingTable") + "/index/*",
+			"dynamodb:GetItem " + table("ReposTable"),
+			"dynamodb:PutItem " + table("RulesTable"),
+			"dynamodb:DeleteItem " + table("RulesTable"),
+		},
+		"appSyncRole": {
+			"lambda:InvokeFunction " + mockArn(functionType, "resolverLambdaFunction"),
+		},
+	}
+	for role, want := range tests {
+		t.Run(role, func(t *testing.T) {
+			got := allowed(t, m.get(t, roleType, role))
+			for _, pair := range want {
+				if !got[pair] {
+					t.Errorf("%s not allowed", pair)
+				}
+			}
+		})
+	}
+
+	// Only Firehose, reading the Glue schema, is allowed actions on any resource
+	wildcards := map[string]bool{"eventsArchiveFirehoseRole": true}
+	for _, role := range m.names(roleType) {
+		for pair := range allowed(t, m.get(t, roleType, role)) {
+			if strings.HasSuffix(pair, " *") && !wildcards[role] {
+				t.Errorf("%s allows %s", role, pair)
+			}
+		}
+	}
+
+	// The fetcher only sends to its queue
+	if got := allowed(t, m.get(t, roleType, "githubEventsFetcherRole")); len(got) != 1 {
+		t.Errorf("githubEventsFetcherRole allows %v", got)
+	}
+
+	// Without rule targets the consumer publishes to no topic
+	for pair := range allowed(t, m.get(t, roleType, "githubEventsConsumerRole")) {
+		if strings.HasPrefix(pair, "sns:") {
+			t.Errorf("githubEventsConsumerRole allows %s", pair)
+		}
+	}
+}
+
+func TestPipelineRuleTargets(t *testing.T) {
+	topic := "arn:aws:sns:us-east-1:123456789012:alerts"
+	queue := "https://sqs.us-east-1.amazonaws.com/123456789012/alerts"
+	m := runPipelinesWith(t, map[string]string{
+		"ruleTargets": `{"topics":["` + topic + `"],"queues":["` + queue + `"]}`,
+	}, defaultPipeline)
+
+	got := allowed(t, m.get(t, roleType, "githubEventsConsumerRole"))
+	for _, pair := range []string{"sns:Publish " + topic, "sqs:SendMessage arn:aws:sqs:us-east-1:123456789012:alerts"} {
+		if !got[pair] {
+			t.Errorf("%s not allowed", pair)
+		}
+	}
+	// The events queue stays out of reach of the rules
+	if got["sqs:SendMessage "+mockArn(queueType, "githubConsumerSQS")] {
+		t.Error("the consumer may send to the events queue")
+	}
+
+	for _, function := range []string{"githubEventsConsumer", "resolverLambdaFunction"} {
+		env := environment(t, m.get(t, functionType, function))
+		if env["RULE_TARGETS"] != topic+","+queue {
+			t.Errorf("%s RULE_TARGETS = %q", function, env["RULE_TARGETS"])
+		}
+	}
+}
+
+func TestPipelineGithubToken(t *testing.T) {
+	m := runPipelines(t, defaultPipeline)
+	if names := m.names(parameterType); len(names) != 1 {
+		t.Errorf("parameters = %v, want only the webhook secret", names)
+	}
+
+	m = runPipelinesWith(t, map[string]string{"githubToken": "token"}, defaultPipeline)
+	parameter := m.get(t, parameterType, "githubToken")
+	if str(parameter["type"]) != "SecureString" {
+		t.Errorf("type = %s, want SecureString", str(parameter["type"]))
+	}
+	for function, role := range map[string]string{"repoEnricher": "repoEnricherRole", "actorEnricher": "actorEnricherRole"} {
+		env := environment(t, m.get(t, functionType, function))
+		if env["GITHUB_TOKEN_PARAMETER"] != str(parameter["name"]) {
+			t.Errorf("%s GITHUB_TOKEN_PARAMETER = %q", function, env["GITHUB_TOKEN_PARAMETER"])
+		}
+		for key, value := range env {
+			if value == "token" {
+				t.Errorf("%s passes the token in %s", function, key)
+			}
+		}
+		if !allowed(t, m.get(t, roleType, role))["ssm:GetParameter "+mockArn(parameterType, "githubToken")] {
+			t.Errorf("%s may not read the token", role)
+		}
+	}
+}
+
+func TestPipelineEventSourceMappings(t *testing.T) {
+	m := runPipelines(t, defaultPipeline)
+
+	tests := []struct {
+		mapping, queue, function string
+		batchSize                float64
+		partialFailures          bool
+	}{
+		{"invokeGithubEventsConsumerLambda", "githubConsumerSQS", "githubEventsConsumer", 10, true},
+		{"invokeActorEnricherLambda", "actorEnrichmentQueue", "actorEnricher", 50, true},
+	}
+	for _, test := range tests {
+		t.Run(test.mapping, func(t *testing.T) {
+			mapping := m.get(t, mappingType, test.mapping)
+			if got := str(mapping["eventSourceArn"]); got != mockArn(queueType, test.queue) {
+				t.Errorf("eventSourceArn = %s", got)
+			}
+			if got := str(mapping["functionName"]); got != test.function {
+				t.Errorf("functionName = %s", got)
+			}
+			if got := mapping["batchSize"].NumberValue(); got != test.batchSize {
+				t.Errorf("batchSize = %v", got)
+			}
+			// Batches of more than 10 messages need a batching window
+			if got := mapping.HasValue("maximumBatchingWindowInSeconds"); got != (test.batchSize > 10) {
+				t.Errorf("batches of %v messages have a batching window = %v", test.batchSize, got)
+			}
+			reports := mapping.HasValue("functionResponseTypes") &&
+				len(mapping["functionResponseTypes"].ArrayValue()) == 1 &&
+				str(mapping["functionResponseTypes"].ArrayValue()[0]) == "ReportBatchItemFailures"
+			if reports != test.partialFailures {
+				t.Errorf("reports batch item failures = %v", reports)
+			}
+		})
+	}
+
+	// Every queue consumed has a visibility timeout covering its consumer and
+	// moves the messages it fails to process to its dead-letter queue
+	for _, test := range tests {
+		queue := m.get(t, queueType, test.queue)
+		function := m.get(t, functionType, test.function)
+		if queue["visibilityTimeoutSeconds"].NumberValue() < function["timeout"].NumberValue() {
+			t.Errorf("%s visibility timeout is shorter than the %s timeout", test.queue, test.function)
+		}
+		var redrive struct{ DeadLetterTargetArn string }
+		err := json.Unmarshal([]byte(str(queue["redrivePolicy"])), &redrive)
+		if err != nil || redrive.DeadLetterTargetArn != mockArn(queueType, test.queue+"DeadLetters") {
+			t.Errorf("%s redrive policy = %s", test.queue, str(queue["redrivePolicy"]))
+		}
+	}
+}
+
+func TestPipelineSchedules(t *testing.T) {
+	m := runPipelines(t, defaultPipeline)
+
+	tests := map[string]struct{ function, expression string }{
+		"fetchSchedule":       {"githubEventsFetcher", "rate(1000 minutes)"},
+		"everyHour":           {"trendingAggregator", "cron(5 * * * ? *)"},
+		"everyHourAnomalies":  {"anomalyDetector", "cron(10 * * * ? *)"},
+		"everyFifteenMinutes": {"repoEnricher", "rate(15 minutes)"},
+	}
+	for rule, want := range tests {
+		t.Run(rule, func(t *testing.T) {
+			if got := str(m.get(t, ruleType, rule)["scheduleExpression"]); got != want.expression {
+				t.Errorf("scheduleExpression = %s", got)
+			}
+			target := m.get(t, targetType, rule)
+			if got := str(target["arn"]); got != mockArn(functionType, want.function) {
+				t.Errorf("target = %s", got)
+			}
+			permission := m.get(t, "aws:lambda/permission:Permission", "allowTrigger"+strings.ToUpper(want.function[:1])+want.function[1:]+"Lambda")
+			if got := str(permission["sourceArn"]); got != mockArn(ruleType, rule) {
+				t.Errorf("permission source = %s", got)
+			}
+		})
+	}
+}
+
+func TestPipelineResolvers(t *testing.T) {
+	m := runPipelines(t, defaultPipeline)
+
+	api := m.get(t, "aws:appsync/graphQLApi:GraphQLApi", "api")
+	if got := str(api["schema"]); got != graphQLSchema {
+		t.Error("the API does not use graphQLSchema")
+	}
+	// The rules are managed with IAM credentials, not the public API key
+	providers := api["additionalAuthenticationProviders"].ArrayValue()
+	if len(providers) != 1 || str(providers[0].ObjectValue()["authenticationType"]) != "AWS_IAM" {
+		t.Errorf("additional authentication providers = %v", providers)
+	}
+	for _, field := range append(schemaFields(t, "Mutation"), "rules") {
+		if !regexp.MustCompile(`(?m)^\s*` + field + `\b.*@aws_iam\s*$`).MatchString(graphQLSchema) {
+			t.Errorf("%s does not require IAM credentials", field)
+		}
+	}
+	dataSource := m.get(t, "aws:appsync/dataSource:DataSource", "dataSource")
+	if got := str(dataSource["lambdaConfig"].ObjectValue()["functionArn"]); got != mockArn(functionType, "resolverLambdaFunction") {
+		t.Errorf("data source function = %s", got)
+	}
+	if got := str(dataSource["serviceRoleArn"]); got != mockArn(roleType, "appSyncRole") {
+		t.Errorf("data source role = %s", got)
+	}
+
+	// Every field of the Query and Mutation types is resolved by the lambda
+	for _, typeName := range []string{"Query", "Mutation"} {
+		fields := schemaFields(t, typeName)
+		if len(fields) == 0 {
+			t.Fatalf("no %s fields in the schema", typeName)
+		}
+		for _, field := range fields {
+			resolver := m.get(t, resolverType, "resolver_"+field)
+			if got := str(resolver["type"]); got != typeName {
+				t.Errorf("resolver_%s type = %s", field, got)
+			}
+			if got := str(resolver["dataSource"]); got != str(dataSource["name"]) {
+				t.Errorf("resolver_%s data source = %s", field, got)
+			}
+			if !strings.Contains(str(resolver["requestTemplate"]), `"field": "`+field+`"`) {
+				t.Errorf("resolver_%s does not pass its field name", field)
+			}
+		}
+	}
+	if got := len(m.names(resolverType)); got != len(schemaFields(t, "Query"))+len(schemaFields(t, "Mutation")) {
+		t.Errorf("%d resolvers for the fields of the schema", got)
+	}
+}
+
+// schemaFields lists the fields of the type in graphQLSchema
+func schemaFields(t *testing.T, typeName string) []string {
+	t.Helper()
+	block := regexp.MustCompile(`(?s)type ` + typeName + ` \{(.*?)\}`).FindStringSubmatch(graphQLSchema)
+	if block == nil {
+		t.Fatalf("no type %s in the schema", typeName)
+	}
+	var fields []string
+	for _, match := range regexp.MustCompile(`(?m)^\s*(\w+)\s*[(:]`).FindAllStringSubmatch(block[1], -1) {
+		fields = append(fields, match[1])
+	}
+	return fields
+}
+
+func TestPipelineShadowTables(t *testing.T) {
+	m := runPipelinesWith(t, map[string]string{"aggregateTables": shadowTables}, defaultPipeline)
+
+	env := environment(t, m.get(t, functionType, "githubEventsConsumer"))
+	if got := env["ACTORS_TABLE"]; got != "actorsTableShadow" {
+		t.Errorf("consumer writes to %s", got)
+	}
+	env = environment(t, m.get(t, functionType, "resolverLambdaFunction"))
+	if got := env["REPOS_TABLE"]; got != "ReposTableShadow" {
+		t.Errorf("resolver reads %s", got)
+	}
+	// The primary set is kept for the next replay
+	m.get(t, tableType, "actorsTable")
+}
+
+func TestPipelinePauseConsumer(t *testing.T) {
+	for config, enabled := range map[string]bool{"false": true, "true": false} {
+		m := runPipelinesWith(t, map[string]string{"pauseConsumer": config}, defaultPipeline)
+		mapping := m.get(t, mappingType, "invokeGithubEventsConsumerLambda")
+		if got := mapping["enabled"].BoolValue(); got != enabled {
+			t.Errorf("pauseConsumer %s: enabled = %v", config, got)
+		}
+	}
+}
+
+func TestPipelineProvisionedTables(t *testing.T) {
+	m := runPipelinesWith(t, map[string]string{
+		"tableBillingMode":   provisioned,
+		"tableReadCapacity":  "7",
+		"tableWriteCapacity": "3",
+	}, defaultPipeline)
+
+	for _, name := range m.names(tableType) {
+		table := m.get(t, tableType, name)
+		for _, key := range []resource.PropertyKey{"pointInTimeRecovery", "serverSideEncryption"} {
+			if !table[key].ObjectValue()["enabled"].BoolValue() {
+				t.Errorf("%s has no %s", name, key)
+			}
+		}
+		if got := str(table["billingMode"]); got != provisioned {
+			t.Errorf("%s billing mode = %s", name, got)
+		}
+		if table["readCapacity"].NumberValue() != 7 || table["writeCapacity"].NumberValue() != 3 {
+			t.Errorf("%s capacity = %v/%v", name, table["readCapacity"], table["writeCapacity"])
+		}
+		if !table.HasValue("globalSecondaryIndexes") {
+			continue
+		}
+		for _, index := range table["globalSecondaryIndexes"].ArrayValue() {
+			index := index.ObjectValue()
+			if index["readCapacity"].NumberValue() != 7 || index["writeCapacity"].NumberValue() != 3 {
+				t.Errorf("%s index %s capacity = %v/%v", name, str(index["name"]), index["readCapacity"], index["writeCapacity"])
+			}
+		}
+	}
+}
+
+func TestSecondPipeline(t *testing.T) {
+	m := runPipelines(t, defaultPipeline, "staging")
+
+	// The second pipeline has its own copy of every resource
+	for key := range m.resources {
+		if strings.Contains(key, "::staging-") {
+			continue
+		}
+		typeToken, name, _ := strings.Cut(key, "::")
+		if strings.HasPrefix(typeToken, "pointfive:") {
+			continue
+		}
+		if _, ok := m.resources[typeToken+"::staging-"+name]; !ok {
+			t.Errorf("no staging copy of %s", key)
+		}
+	}
+
+	env := environment(t, m.get(t, functionType, "staging-githubEventsFetcher"))
+	if got := env["GITHUB_CONSUMER_SQS_URL"]; got != str(m.get(t, queueType, "staging-githubConsumerSQS")["url"]) {
+		t.Errorf("staging fetcher sends to %s", got)
+	}
+	database := m.get(t, "aws:glue/catalogDatabase:CatalogDatabase", "staging-eventsArchiveDatabase")
+	if got := str(database["name"]); got != "github_archive_test_staging" {
+		t.Errorf("staging archive database = %s", got)
+	}
+}
+
+func TestProgram(t *testing.T) {
+	m, err := run(t, map[string]string{"githubWebhookSecret": "secret"}, program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(m.names(functionType)); got != len(lambdaModules) {
+		t.Errorf("%d lambdas", got)
+	}
+}
+
+func TestPipelineMonitoring(t *testing.T) {
+	m := runPipelinesWith(t, map[string]string{"alarmEmail": "ops@example.com"}, defaultPipeline)
+
+	topic := mockArn("aws:sns/topic:Topic", "operationsTopic")
+	subscription := m.get(t, "aws:sns/topicSubscription:TopicSubscription", "operationsEmail")
+	if str(subscription["topic"]) != topic || str(subscription["endpoint"]) != "ops@example.com" {
+		t.Errorf("subscription = %v", subscription)
+	}
+
+	tests := map[string]struct{ metric, dimension, value string }{
+		"fetcherErrorsAlarm":         {"Errors", "FunctionName", "githubEventsFetcher"},
+		"consumerErrorsAlarm":        {"Errors", "FunctionName", "githubEventsConsumer"},
+		"consumerWriteFailuresAlarm": {"EventWriteFailures", "FunctionName", "githubEventsConsumer"},
+		"consumerThrottlesAlarm":     {"Throttles", "FunctionName", "githubEventsConsumer"},
+		"consumerQueueAgeAlarm":      {"ApproximateAgeOfOldestMessage", "QueueName", "githubConsumerSQS"},
+		"consumerDeadLettersAlarm":   {"ApproximateNumberOfMessagesVisible", "QueueName", "githubConsumerSQSDeadLetters"},
+		"enrichmentDeadLettersAlarm": {"ApproximateNumberOfMessagesVisible", "QueueName", "actorEnrichmentQueueDeadLetters"},
+		"apiErrorsAlarm":             {"5XXError", "GraphQLAPIId", "api_id"},
+	}
+	alarmType := "aws:cloudwatch/metricAlarm:MetricAlarm"
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			alarm := m.get(t, alarmType, name)
+			if got := str(alarm["metricName"]); got != want.metric {
+				t.Errorf("metric = %s", got)
+			}
+			if got := str(alarm["dimensions"].ObjectValue()[resource.PropertyKey(want.dimension)]); got != want.value {
+				t.Errorf("%s = %s", want.dimension, got)
+			}
+			for _, actions := range []resource.PropertyKey{"alarmActions", "okActions"} {
+				if got := alarm[actions].ArrayValue(); len(got) != 1 || str(got[0]) != topic {
+					t.Errorf("%s = %v", actions, got)
+				}
+			}
+		})
+	}
+	if got := len(m.names(alarmType)); got != len(tests) {
+		t.Errorf("%d alarms", got)
+	}
+
+	// The dashboard graphs the queue and queries the logs of the consumer
+	dashboard := m.get(t, "aws:cloudwatch/dashboard:Dashboard", "operationsDashboard")
+	var body struct {
+		Widgets []struct {
+			Type       string
+			Properties map[string]interface{}
+		}
+	}
+	err := json.Unmarshal([]byte(str(dashboard["dashboardBody"])), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	widgets := map[string]map[string]interface{}{}
+	for _, widget := range body.Widgets {
+		widgets[fmt.Sprint(widget.Properties["title"])] = widget.Properties
+	}
+	if got := widgets["Alarms"]["alarms"]; len(got.([]interface{})) != len(tests) {
+		t.Errorf("alarm widget = %v", got)
+	}
+	if got := fmt.Sprint(widgets["Events ingested per fetcher run"]["metrics"]); !strings.Contains(got, "EventsFetched FunctionName githubEventsFetcher") {
+		t.Errorf("events per run metrics = %s", got)
+	}
+	if got := fmt.Sprint(widgets["Events consumed per type"]["metrics"]); !strings.Contains(got, `MetricName="EventsConsumed" FunctionName="githubEventsConsumer"`) {
+		t.Errorf("events per type metrics = %s", got)
+	}
+	if got := fmt.Sprint(widgets["Resolver latency p90 per field (ms)"]["metrics"]); !strings.Contains(got, `{PointFive,FunctionName,Field}`) || !strings.Contains(got, `FunctionName="resolverLambdaFunction"`) {
+		t.Errorf("resolver latency metrics = %s", got)
+	}
+}
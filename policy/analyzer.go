@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	packName    = "pointfive"
+	packVersion = "1.0.0"
+)
+
+// analyzer serves the policies to the Pulumi engine, which calls Analyze with
+// the inputs of each resource and AnalyzeStack with the outputs of the stack
+type analyzer struct {
+	pulumirpc.UnimplementedAnalyzerServer
+
+	now func() time.Time
+
+	mu     sync.Mutex
+	levels map[string]pulumirpc.EnforcementLevel
+	config settings
+}
+
+func newAnalyzer(now func() time.Time) *analyzer {
+	levels := map[string]pulumirpc.EnforcementLevel{}
+	for _, p := range policies {
+		levels[p.Name] = p.EnforcementLevel
+	}
+	return &analyzer{now: now, levels: levels, config: settings{MaxApiKeyDays: defaultMaxApiKeyDays}}
+}
+
+// settings returns the configured enforcement levels and settings
+func (a *analyzer) settings() (map[string]pulumirpc.EnforcementLevel, settings) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.config
+	s.Now = a.now()
+	return a.levels, s
+}
+
+func unmarshalResource(urn, typ, name string, properties *structpb.Struct) (analyzedResource, error) {
+	props, err := plugin.UnmarshalProperties(properties, plugin.MarshalOptions{KeepUnknowns: true, KeepSecrets: true})
+	if err != nil {
+		return analyzedResource{}, fmt.Errorf("unmarshal %s: %w", urn, err)
+	}
+	return analyzedResource{URN: urn, Type: typ, Name: name, Properties: props}, nil
+}
+
+func diagnostic(p policy, level pulumirpc.EnforcementLevel, urn, message string) *pulumirpc.AnalyzeDiagnostic {
+	return &pulumirpc.AnalyzeDiagnostic{
+		PolicyName:        p.Name,
+		PolicyPackName:    packName,
+		PolicyPackVersion: packVersion,
+		Description:       p.Description,
+		Message:           message,
+		EnforcementLevel:  level,
+		Urn:               urn,
+	}
+}
+
+func (a *analyzer) Analyze(ctx context.Context, req *pulumirpc.AnalyzeRequest) (*pulumirpc.AnalyzeResponse, error) {
+	r, err := unmarshalResource(req.Urn, req.Type, req.Name, req.Properties)
+	if err != nil {
+		return nil, err
+	}
+
+	levels, s := a.settings()
+	response := &pulumirpc.AnalyzeResponse{}
+	for _, p := range policies {
+		if p.validateResource == nil || levels[p.Name] == pulumirpc.EnforcementLevel_DISABLED {
+			continue
+		}
+		for _, message := range p.validateResource(r, s) {
+			response.Diagnostics = append(response.Diagnostics, diagnostic(p, levels[p.Name], r.URN, message))
+		}
+	}
+	return response, nil
+}
+
+func (a *analyzer) AnalyzeStack(ctx context.Context, req *pulumirpc.AnalyzeStackRequest) (*pulumirpc.AnalyzeResponse, error) {
+	resources := make([]analyzedResource, 0, len(req.Resources))
+	for _, resource := range req.Resources {
+		r, err := unmarshalResource(resource.Urn, resource.Type, resource.Name, resource.Properties)
+		if err != nil {
+			return nil, err
+		}
+		r.PropertyDependencies = map[string][]string{}
+		for key, dependencies := range resource.PropertyDependencies {
+			r.PropertyDependencies[key] = dependencies.Urns
+		}
+		resources = append(resources, r)
+	}
+
+	levels, s := a.settings()
+	response := &pulumirpc.AnalyzeResponse{}
+	for _, p := range policies {
+		if p.validateStack == nil || levels[p.Name] == pulumirpc.EnforcementLevel_DISABLED {
+			continue
+		}
+		for _, v := range p.validateStack(resources, s) {
+			response.Diagnostics = append(response.Diagnostics, diagnostic(p, levels[p.Name], v.URN, v.Message))
+		}
+	}
+	return response, nil
+}
+
+func (a *analyzer) GetAnalyzerInfo(context.Context, *emptypb.Empty) (*pulumirpc.AnalyzerInfo, error) {
+	maxDays, err := structpb.NewStruct(map[string]interface{}{
+		"maxDays": map[string]interface{}{"type": "integer", "minimum": 1, "default": defaultMaxApiKeyDays},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := &pulumirpc.AnalyzerInfo{
+		Name:           packName,
+		DisplayName:    "PointFive stack standards",
+		Version:        packVersion,
+		SupportsConfig: true,
+	}
+	for _, p := range policies {
+		policyInfo := &pulumirpc.PolicyInfo{
+			Name:             p.Name,
+			DisplayName:      p.Name,
+			Description:      p.Description,
+			EnforcementLevel: p.EnforcementLevel,
+		}
+		if p.Name == "appsync-api-key-expiry" {
+			policyInfo.ConfigSchema = &pulumirpc.PolicyConfigSchema{Properties: maxDays}
+		}
+		info.Policies = append(info.Policies, policyInfo)
+	}
+	return info, nil
+}
+
+func (a *analyzer) GetPluginInfo(context.Context, *emptypb.Empty) (*pulumirpc.PluginInfo, error) {
+	return &pulumirpc.PluginInfo{Version: packVersion}, nil
+}
+
+// Configure applies the policy pack config, the enforcement level of any
+// policy and maxDays of appsync-api-key-expiry
+func (a *analyzer) Configure(ctx context.Context, req *pulumirpc.ConfigureAnalyzerRequest) (*emptypb.Empty, error) {
+	levels := map[string]pulumirpc.EnforcementLevel{}
+	for _, p := range policies {
+		levels[p.Name] = p.EnforcementLevel
+	}
+	config := settings{MaxApiKeyDays: defaultMaxApiKeyDays}
+
+	for name, policyConfig := range req.PolicyConfig {
+		if _, ok := levels[name]; !ok {
+			return nil, fmt.Errorf("unknown policy %q", name)
+		}
+		levels[name] = policyConfig.EnforcementLevel
+		if name != "appsync-api-key-expiry" || policyConfig.Properties == nil {
+			continue
+		}
+		if value, ok := policyConfig.Properties.Fields["maxDays"]; ok {
+			days := int(value.GetNumberValue())
+			if days < 1 {
+				return nil, fmt.Errorf("appsync-api-key-expiry: maxDays must be at least 1, got %v", value.AsInterface())
+			}
+			config.MaxApiKeyDays = days
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.levels = levels
+	a.config = config
+	return &emptypb.Empty{}, nil
+}
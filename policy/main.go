@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/rpcutil"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/grpc"
+)
+
+// The policy pack is the analyzer plugin the engine starts for the go runtime
+// of PulumiPolicy.yaml, built as pulumi-analyzer-policy-go. It serves the
+// Analyzer gRPC service and writes its port to stdout for the engine to
+// connect, the engine address and pack directory arguments are not needed
+func main() {
+	handle, err := rpcutil.ServeWithOptions(rpcutil.ServeOptions{
+		Init: func(srv *grpc.Server) error {
+			pulumirpc.RegisterAnalyzerServer(srv, newAnalyzer(time.Now))
+			return nil
+		},
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to serve the policy pack:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(handle.Port)
+
+	err = <-handle.Done
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Policy pack stopped:", err)
+		os.Exit(1)
+	}
+}
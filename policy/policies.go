@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+const (
+	roleType     = "aws:iam/role:Role"
+	tableType    = "aws:dynamodb/table:Table"
+	queueType    = "aws:sqs/queue:Queue"
+	functionType = "aws:lambda/function:Function"
+	logGroupType = "aws:cloudwatch/logGroup:LogGroup"
+	apiKeyType   = "aws:appsync/apiKey:ApiKey"
+)
+
+// policyTypes hold their IAM policy document in their policy property
+var policyTypes = map[string]bool{
+	"aws:iam/policy:Policy":           true,
+	"aws:iam/rolePolicy:RolePolicy":   true,
+	"aws:iam/userPolicy:UserPolicy":   true,
+	"aws:iam/groupPolicy:GroupPolicy": true,
+}
+
+// analyzedResource is a resource of the stack, its inputs when analyzed
+// alone and its outputs when analyzed with the stack. Values not known yet,
+// in previews of resources to create, are unknown and pass the policies, they
+// are checked by the update
+type analyzedResource struct {
+	URN        string
+	Type       string
+	Name       string
+	Properties resource.PropertyMap
+	// URNs of the resources each property depends on, stack only
+	PropertyDependencies map[string][]string
+}
+
+// settings are the policy config values
+type settings struct {
+	// Days ahead API keys may expire
+	MaxApiKeyDays int
+	Now           time.Time
+}
+
+const defaultMaxApiKeyDays = 365
+
+type violation struct {
+	URN     string
+	Message string
+}
+
+// policy checks a standard either on each resource or on the whole stack
+type policy struct {
+	Name             string
+	Description      string
+	EnforcementLevel pulumirpc.EnforcementLevel
+	validateResource func(r analyzedResource, s settings) []string
+	validateStack    func(resources []analyzedResource, s settings) []violation
+}
+
+var policies = []policy{
+	{
+		Name:             "iam-no-wildcard-actions",
+		Description:      "IAM policies list the actions they allow, without wildcards or NotAction.",
+		EnforcementLevel: pulumirpc.EnforcementLevel_MANDATORY,
+		validateResource: wildcardActions,
+	},
+	{
+		Name:             "dynamodb-pitr-and-encryption",
+		Description:      "DynamoDB tables have point-in-time recovery and server-side encryption enabled.",
+		EnforcementLevel: pulumirpc.EnforcementLevel_MANDATORY,
+		validateResource: tableProtection,
+	},
+	{
+		Name:             "sqs-dead-letter-queue",
+		Description:      "SQS queues have a dead-letter queue, unless they are one.",
+		EnforcementLevel: pulumirpc.EnforcementLevel_MANDATORY,
+		validateStack:    deadLetterQueues,
+	},
+	{
+		Name:             "lambda-timeout",
+		Description:      "Lambda functions set their timeout.",
+		EnforcementLevel: pulumirpc.EnforcementLevel_MANDATORY,
+		validateResource: functionTimeout,
+	},
+	{
+		Name:             "lambda-log-retention",
+		Description:      "Lambda functions have a log group with a retention.",
+		EnforcementLevel: pulumirpc.EnforcementLevel_MANDATORY,
+		validateStack:    logRetention,
+	},
+	{
+		Name:             "appsync-api-key-expiry",
+		Description:      "AppSync API keys expire within maxDays days, 365 by default.",
+		EnforcementLevel: pulumirpc.EnforcementLevel_MANDATORY,
+		validateResource: apiKeyExpiry,
+	},
+}
+
+// stringValue returns the string of a known string property, secret or not
+func stringValue(v resource.PropertyValue) (string, bool) {
+	if v.IsSecret() {
+		v = v.SecretValue().Element
+	}
+	if !v.IsString() {
+		return "", false
+	}
+	return v.StringValue(), true
+}
+
+// unknown tells if the value, or a value it holds, is not known yet
+func unknown(v resource.PropertyValue) bool {
+	return v.ContainsUnknowns()
+}
+
+// stringList is an IAM Action or Resource, a string or a list of strings
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var value string
+	if json.Unmarshal(data, &value) == nil {
+		*l = []string{value}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+type policyStatement struct {
+	Sid       string
+	Effect    string
+	Action    stringList
+	NotAction stringList
+}
+
+// statements parses the statements of an IAM policy document, a single
+// statement or a list of them
+func statements(document string) ([]policyStatement, error) {
+	var parsed struct {
+		Statement json.RawMessage
+	}
+	err := json.Unmarshal([]byte(document), &parsed)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(parsed.Statement)), "{") {
+		var statement policyStatement
+		err = json.Unmarshal(parsed.Statement, &statement)
+		return []policyStatement{statement}, err
+	}
+	var list []policyStatement
+	err = json.Unmarshal(parsed.Statement, &list)
+	return list, err
+}
+
+// wildcardActions rejects the Allow statements of IAM policies allowing
+// actions by wildcard or allowing every action but some
+func wildcardActions(r analyzedResource, _ settings) []string {
+	documents := map[string]resource.PropertyValue{}
+	switch {
+	case r.Type == roleType:
+		inline := r.Properties["inlinePolicies"]
+		if !inline.IsArray() {
+			break
+		}
+		for i, policy := range inline.ArrayValue() {
+			if !policy.IsObject() {
+				continue
+			}
+			name, ok := stringValue(policy.ObjectValue()["name"])
+			if !ok {
+				name = fmt.Sprintf("inline policy %d", i)
+			}
+			documents[name] = policy.ObjectValue()["policy"]
+		}
+	case policyTypes[r.Type]:
+		documents["policy"] = r.Properties["policy"]
+	}
+
+	var violations []string
+	for name, value := range documents {
+		document, ok := stringValue(value)
+		if !ok || document == "" {
+			continue
+		}
+		parsed, err := statements(document)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("%s is not a valid policy document: %v", name, err))
+			continue
+		}
+		for _, statement := range parsed {
+			if statement.Effect != "Allow" {
+				continue
+			}
+			if len(statement.NotAction) > 0 {
+				violations = append(violations, fmt.Sprintf("%s allows every action but %s", name, strings.Join(statement.NotAction, ", ")))
+			}
+			for _, action := range statement.Action {
+				if strings.Contains(action, "*") {
+					violations = append(violations, fmt.Sprintf("%s allows %s, list the actions instead", name, action))
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// enabled tells if the enabled flag of the block property is set, or not
+// known yet
+func enabled(properties resource.PropertyMap, key resource.PropertyKey) bool {
+	block := properties[key]
+	if unknown(block) {
+		return true
+	}
+	if !block.IsObject() {
+		return false
+	}
+	flag := block.ObjectValue()["enabled"]
+	return unknown(flag) || flag.IsBool() && flag.BoolValue()
+}
+
+// tableProtection requires point-in-time recovery and server-side encryption
+// on DynamoDB tables
+func tableProtection(r analyzedResource, _ settings) []string {
+	if r.Type != tableType {
+		return nil
+	}
+	var violations []string
+	if !enabled(r.Properties, "pointInTimeRecovery") {
+		violations = append(violations, "point-in-time recovery is not enabled, set pointInTimeRecovery.enabled")
+	}
+	if !enabled(r.Properties, "serverSideEncryption") {
+		violations = append(violations, "server-side encryption is not enabled, set serverSideEncryption.enabled")
+	}
+	return violations
+}
+
+// deadLetterQueues requires a redrive policy on every queue that is not the
+// dead-letter queue of another one
+func deadLetterQueues(resources []analyzedResource, _ settings) []violation {
+	// URNs and ARNs of the dead-letter queues
+	deadLetters := map[string]bool{}
+	for _, r := range resources {
+		if r.Type != queueType {
+			continue
+		}
+		for _, urn := range r.PropertyDependencies["redrivePolicy"] {
+			deadLetters[urn] = true
+		}
+		if redrive, ok := stringValue(r.Properties["redrivePolicy"]); ok && redrive != "" {
+			var parsed struct {
+				DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+			}
+			if json.Unmarshal([]byte(redrive), &parsed) == nil && parsed.DeadLetterTargetArn != "" {
+				deadLetters[parsed.DeadLetterTargetArn] = true
+			}
+		}
+	}
+
+	var violations []violation
+	for _, r := range resources {
+		if r.Type != queueType {
+			continue
+		}
+		redrive := r.Properties["redrivePolicy"]
+		if value, ok := stringValue(redrive); unknown(redrive) || ok && value != "" {
+			continue
+		}
+		if arn, ok := stringValue(r.Properties["arn"]); deadLetters[r.URN] || ok && deadLetters[arn] {
+			continue
+		}
+		violations = append(violations, violation{r.URN, "the queue has no dead-letter queue, set its redrivePolicy"})
+	}
+	return violations
+}
+
+// functionTimeout requires lambdas to set their timeout rather than run with
+// the 3 seconds default
+func functionTimeout(r analyzedResource, _ settings) []string {
+	if r.Type != functionType {
+		return nil
+	}
+	timeout := r.Properties["timeout"]
+	if unknown(timeout) || timeout.IsNumber() && timeout.NumberValue() > 0 {
+		return nil
+	}
+	return []string{"timeout is not set"}
+}
+
+// logRetention requires every lambda to have a log group with a retention,
+// matched by the name of the function or by depending on it. Without one
+// the lambda creates a log group keeping its logs forever
+func logRetention(resources []analyzedResource, _ settings) []violation {
+	byName := map[string]bool{}
+	byURN := map[string]bool{}
+	for _, r := range resources {
+		if r.Type != logGroupType {
+			continue
+		}
+		retention := r.Properties["retentionInDays"]
+		if !unknown(retention) && !(retention.IsNumber() && retention.NumberValue() > 0) {
+			continue
+		}
+		if name, ok := stringValue(r.Properties["name"]); ok {
+			byName[strings.TrimPrefix(name, "/aws/lambda/")] = true
+		}
+		for _, urn := range r.PropertyDependencies["name"] {
+			byURN[urn] = true
+		}
+	}
+
+	var violations []violation
+	for _, r := range resources {
+		if r.Type != functionType || byURN[r.URN] {
+			continue
+		}
+		if name, ok := stringValue(r.Properties["name"]); ok && byName[name] {
+			continue
+		}
+		violations = append(violations, violation{r.URN, "the function has no log group with a retention, create /aws/lambda/<function name> with retentionInDays"})
+	}
+	return violations
+}
+
+// apiKeyExpiry requires AppSync API keys to set an expiry within
+// MaxApiKeyDays days
+func apiKeyExpiry(r analyzedResource, s settings) []string {
+	if r.Type != apiKeyType {
+		return nil
+	}
+	expires := r.Properties["expires"]
+	if unknown(expires) {
+		return nil
+	}
+	value, ok := stringValue(expires)
+	if !ok || value == "" {
+		return []string{"expires is not set"}
+	}
+	expiry, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return []string{fmt.Sprintf("expires %q is not an RFC 3339 time", value)}
+	}
+	if expiry.After(s.Now.AddDate(0, 0, s.MaxApiKeyDays)) {
+		return []string{fmt.Sprintf("expires %s, more than %d days ahead", value, s.MaxApiKeyDays)}
+	}
+	return nil
+}
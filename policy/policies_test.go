@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var now = time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+func testResource(typ, name string, properties map[string]interface{}) analyzedResource {
+	return analyzedResource{
+		URN:                  "urn:pulumi:test::pointfive_pulumi::" + typ + "::" + name,
+		Type:                 typ,
+		Name:                 name,
+		Properties:           resource.NewPropertyMapFromMap(properties),
+		PropertyDependencies: map[string][]string{},
+	}
+}
+
+func computed() resource.PropertyValue {
+	return resource.MakeComputed(resource.NewStringProperty(""))
+}
+
+func TestResourcePolicies(t *testing.T) {
+	s := settings{MaxApiKeyDays: defaultMaxApiKeyDays, Now: now}
+	rolePolicy := func(document string) map[string]interface{} {
+		return map[string]interface{}{"inlinePolicies": []interface{}{
+			map[string]interface{}{"name": "consumerPolicy", "policy": document},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		validate func(analyzedResource, settings) []string
+		resource analyzedResource
+		want     []string
+	}{
+		{
+			"explicit actions", wildcardActions,
+			testResource(roleType, "consumer", rolePolicy(`{"Statement":[{"Effect":"Allow","Action":["sqs:SendMessage"],"Resource":["*"]}]}`)),
+			nil,
+		},
+		{
+			"wildcard action", wildcardActions,
+			testResource(roleType, "consumer", rolePolicy(`{"Statement":[{"Effect":"Allow","Action":["dynamodb:*","sqs:SendMessage"],"Resource":"*"}]}`)),
+			[]string{"consumerPolicy allows dynamodb:*"},
+		},
+		{
+			"single statement", wildcardActions,
+			testResource("aws:iam/rolePolicy:RolePolicy", "extra", map[string]interface{}{"policy": `{"Statement":{"Effect":"Allow","Action":"*","Resource":"*"}}`}),
+			[]string{"policy allows *"},
+		},
+		{
+			"not action", wildcardActions,
+			testResource("aws:iam/policy:Policy", "extra", map[string]interface{}{"policy": `{"Statement":[{"Effect":"Allow","NotAction":"iam:*","Resource":"*"}]}`}),
+			[]string{"policy allows every action but iam:*"},
+		},
+		{
+			"denied wildcard", wildcardActions,
+			testResource("aws:iam/policy:Policy", "extra", map[string]interface{}{"policy": `{"Statement":[{"Effect":"Deny","Action":"*","Resource":"*"}]}`}),
+			nil,
+		},
+		{
+			"unknown policy", wildcardActions,
+			analyzedResource{Type: roleType, Properties: resource.PropertyMap{"inlinePolicies": resource.NewArrayProperty([]resource.PropertyValue{
+				resource.NewObjectProperty(resource.PropertyMap{"name": resource.NewStringProperty("consumerPolicy"), "policy": computed()}),
+			})}},
+			nil,
+		},
+		{
+			"protected table", tableProtection,
+			testResource(tableType, "actors", map[string]interface{}{
+				"pointInTimeRecovery":  map[string]interface{}{"enabled": true},
+				"serverSideEncryption": map[string]interface{}{"enabled": true},
+			}),
+			nil,
+		},
+		{
+			"unprotected table", tableProtection,
+			testResource(tableType, "actors", map[string]interface{}{
+				"pointInTimeRecovery": map[string]interface{}{"enabled": false},
+			}),
+			[]string{"point-in-time recovery is not enabled", "server-side encryption is not enabled"},
+		},
+		{
+			"timeout", functionTimeout,
+			testResource(functionType, "fetcher", map[string]interface{}{"timeout": 3}),
+			nil,
+		},
+		{
+			"no timeout", functionTimeout,
+			testResource(functionType, "fetcher", map[string]interface{}{}),
+			[]string{"timeout is not set"},
+		},
+		{
+			"api key expiry", apiKeyExpiry,
+			testResource(apiKeyType, "key", map[string]interface{}{"expires": "2025-02-01T00:00:00Z"}),
+			nil,
+		},
+		{
+			"api key expiry too far", apiKeyExpiry,
+			testResource(apiKeyType, "key", map[string]interface{}{"expires": "2025-04-01T00:00:00Z"}),
+			[]string{"more than 365 days ahead"},
+		},
+		{
+			"api key without expiry", apiKeyExpiry,
+			testResource(apiKeyType, "key", map[string]interface{}{}),
+			[]string{"expires is not set"},
+		},
+		{
+			"unknown api key expiry", apiKeyExpiry,
+			analyzedResource{Type: apiKeyType, Properties: resource.PropertyMap{"expires": computed()}},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.validate(test.resource, s)
+			if len(got) != len(test.want) {
+				t.Fatalf("violations = %q, want %q", got, test.want)
+			}
+			for i, message := range got {
+				if !strings.Contains(message, test.want[i]) {
+					t.Errorf("violation %q, want %q", message, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDeadLetterQueues(t *testing.T) {
+	deadLetters := testResource(queueType, "consumerDeadLetters", map[string]interface{}{"arn": "arn:aws:sqs:us-east-1:123456789012:consumerDeadLetters"})
+	byArn := testResource(queueType, "consumer", map[string]interface{}{
+		"redrivePolicy": `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:123456789012:consumerDeadLetters","maxReceiveCount":5}`,
+	})
+	if got := deadLetterQueues([]analyzedResource{deadLetters, byArn}, settings{}); len(got) != 0 {
+		t.Errorf("violations = %v", got)
+	}
+
+	// In previews the redrive policy is not known yet, its dependencies are
+	unknownDeadLetters := testResource(queueType, "consumerDeadLetters", map[string]interface{}{})
+	unknownDeadLetters.Properties["arn"] = computed()
+	byDependency := testResource(queueType, "consumer", map[string]interface{}{})
+	byDependency.Properties["redrivePolicy"] = computed()
+	byDependency.PropertyDependencies["redrivePolicy"] = []string{unknownDeadLetters.URN}
+	if got := deadLetterQueues([]analyzedResource{unknownDeadLetters, byDependency}, settings{}); len(got) != 0 {
+		t.Errorf("violations = %v", got)
+	}
+
+	orphan := testResource(queueType, "enrichment", map[string]interface{}{"redrivePolicy": ""})
+	got := deadLetterQueues([]analyzedResource{deadLetters, byArn, orphan}, settings{})
+	if len(got) != 1 || got[0].URN != orphan.URN {
+		t.Errorf("violations = %v, want %s", got, orphan.URN)
+	}
+}
+
+func TestLogRetention(t *testing.T) {
+	byName := testResource(functionType, "fetcher", map[string]interface{}{"name": "fetcher-1234"})
+	byNameLogs := testResource(logGroupType, "fetcherLogs", map[string]interface{}{"name": "/aws/lambda/fetcher-1234", "retentionInDays": 14})
+	byDependency := testResource(functionType, "consumer", map[string]interface{}{})
+	byDependencyLogs := testResource(logGroupType, "consumerLogs", map[string]interface{}{"retentionInDays": 14})
+	byDependencyLogs.Properties["name"] = computed()
+	byDependencyLogs.PropertyDependencies["name"] = []string{byDependency.URN}
+	if got := logRetention([]analyzedResource{byName, byNameLogs, byDependency, byDependencyLogs}, settings{}); len(got) != 0 {
+		t.Errorf("violations = %v", got)
+	}
+
+	forever := testResource(functionType, "webhook", map[string]interface{}{"name": "webhook-1234"})
+	foreverLogs := testResource(logGroupType, "webhookLogs", map[string]interface{}{"name": "/aws/lambda/webhook-1234"})
+	orphan := testResource(functionType, "resolver", map[string]interface{}{"name": "resolver-1234"})
+	got := logRetention([]analyzedResource{byName, byNameLogs, forever, foreverLogs, orphan}, settings{})
+	if len(got) != 2 || got[0].URN != forever.URN || got[1].URN != orphan.URN {
+		t.Errorf("violations = %v, want %s and %s", got, forever.URN, orphan.URN)
+	}
+}
+
+func marshal(t *testing.T, properties map[string]interface{}) *structpb.Struct {
+	t.Helper()
+	props, err := plugin.MarshalProperties(resource.NewPropertyMapFromMap(properties), plugin.MarshalOptions{KeepUnknowns: true, KeepSecrets: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return props
+}
+
+func TestAnalyzer(t *testing.T) {
+	a := newAnalyzer(func() time.Time { return now })
+	ctx := context.Background()
+
+	table := &pulumirpc.AnalyzeRequest{
+		Urn:        "urn:pulumi:test::pointfive_pulumi::aws:dynamodb/table:Table::actors",
+		Type:       tableType,
+		Name:       "actors",
+		Properties: marshal(t, map[string]interface{}{"serverSideEncryption": map[string]interface{}{"enabled": true}}),
+	}
+	response, err := a.Analyze(ctx, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Diagnostics) != 1 {
+		t.Fatalf("diagnostics = %v", response.Diagnostics)
+	}
+	d := response.Diagnostics[0]
+	if d.PolicyName != "dynamodb-pitr-and-encryption" || d.EnforcementLevel != pulumirpc.EnforcementLevel_MANDATORY || d.Urn != table.Urn || d.PolicyPackName != packName {
+		t.Errorf("diagnostic = %v", d)
+	}
+
+	queue := &pulumirpc.AnalyzerResource{
+		Urn:        "urn:pulumi:test::pointfive_pulumi::aws:sqs/queue:Queue::consumer",
+		Type:       queueType,
+		Name:       "consumer",
+		Properties: marshal(t, map[string]interface{}{}),
+	}
+	response, err = a.AnalyzeStack(ctx, &pulumirpc.AnalyzeStackRequest{Resources: []*pulumirpc.AnalyzerResource{queue}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Diagnostics) != 1 || response.Diagnostics[0].PolicyName != "sqs-dead-letter-queue" {
+		t.Errorf("diagnostics = %v", response.Diagnostics)
+	}
+
+	// The policy pack config sets the enforcement levels and maxDays
+	maxDays, err := structpb.NewStruct(map[string]interface{}{"maxDays": 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.Configure(ctx, &pulumirpc.ConfigureAnalyzerRequest{PolicyConfig: map[string]*pulumirpc.PolicyConfig{
+		"dynamodb-pitr-and-encryption": {EnforcementLevel: pulumirpc.EnforcementLevel_DISABLED},
+		"appsync-api-key-expiry":       {EnforcementLevel: pulumirpc.EnforcementLevel_ADVISORY, Properties: maxDays},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = a.Analyze(ctx, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Diagnostics) != 0 {
+		t.Errorf("disabled policy diagnostics = %v", response.Diagnostics)
+	}
+	response, err = a.Analyze(ctx, &pulumirpc.AnalyzeRequest{
+		Urn:        "urn:pulumi:test::pointfive_pulumi::aws:appsync/apiKey:ApiKey::key",
+		Type:       apiKeyType,
+		Name:       "key",
+		Properties: marshal(t, map[string]interface{}{"expires": "2024-05-01T00:00:00Z"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Diagnostics) != 1 || response.Diagnostics[0].EnforcementLevel != pulumirpc.EnforcementLevel_ADVISORY ||
+		!strings.Contains(response.Diagnostics[0].Message, "more than 30 days ahead") {
+		t.Errorf("diagnostics = %v", response.Diagnostics)
+	}
+
+	_, err = a.Configure(ctx, &pulumirpc.ConfigureAnalyzerRequest{PolicyConfig: map[string]*pulumirpc.PolicyConfig{"lambda-memory": {}}})
+	if err == nil {
+		t.Error("unknown policies are configured")
+	}
+}
+
+func TestAnalyzerInfo(t *testing.T) {
+	info, err := newAnalyzer(time.Now).GetAnalyzerInfo(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Policies) != len(policies) || !info.SupportsConfig {
+		t.Errorf("info = %v", info)
+	}
+	for _, p := range policies {
+		if (p.validateResource == nil) == (p.validateStack == nil) {
+			t.Errorf("%s must validate either resources or the stack", p.Name)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/dynamodb"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/kinesis"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/sqs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type EventProcessorArgs struct {
+	// Queue of the ingested events
+	Queue *sqs.Queue
+	// Firehose stream archiving the raw events
+	ArchiveStream *kinesis.FirehoseDeliveryStream
+	// Aggregate table set the consumer writes to, primary or shadow
+	LiveTables string
+	// Create the shadow table set even when it is not live
+	ShadowTables bool
+	// Disable the queue trigger of the consumer, the events wait in the queue
+	PauseConsumer bool
+	// Events passed to each consumer invocation
+	BatchSize     int
+	TableSettings tableSettings
+	// SNS topics and SQS queues alert rules may deliver to
+	RuleTargets ruleTargets
+	Consumer    lambdaSettings
+}
+
+// EventProcessor consumes the queued events into the aggregate tables,
+// evaluates the alert rules and archives the raw events
+type EventProcessor struct {
+	pulumi.ResourceState
+	component
+
+	Tables *aggregateTables
+	// Set rebuilt by replays, nil without a shadow set
+	ReplayTables    *aggregateTables
+	RulesTable      *dynamodb.Table
+	EnrichmentQueue *sqs.Queue
+	// Logins the actor enricher failed to fetch
+	EnrichmentDeadLetters *sqs.Queue
+	Consumer              *lambda.Function
+}
+
+func NewEventProcessor(ctx *pulumi.Context, name string, args *EventProcessorArgs, opts ...pulumi.ResourceOption) (*EventProcessor, error) {
+	c := &EventProcessor{}
+	c.component = component{name: name, resource: c}
+	err := ctx.RegisterComponentResource("pointfive:pipeline:EventProcessor", name, c, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Tables, c.ReplayTables, err = selectAggregateTables(ctx, &c.component, args.TableSettings, args.LiveTables, args.ShadowTables)
+	if err != nil {
+		return nil, err
+	}
+
+	// User defined alert rules evaluated by the consumer
+	c.RulesTable, err = dynamodb.NewTable(ctx, c.resourceName("RulesTable"), args.TableSettings.apply(&dynamodb.TableArgs{
+		Attributes: dynamodb.TableAttributeArray{
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("RuleId"),
+				Type: pulumi.String("S"),
+			},
+		},
+		HashKey:    pulumi.String("RuleId"),
+		TableClass: pulumi.String("STANDARD"),
+	}), c.childOptions("RulesTable")...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Logins seen for the first time, waiting for their profile to be fetched
+	// Messages are retried until the GitHub rate limit allows fetching them,
+	// so only logins failing for most of the retention are dead letters
+	c.EnrichmentQueue, c.EnrichmentDeadLetters, err = c.newQueue(ctx, "actorEnrichmentQueue", 300, &sqs.QueueArgs{
+		VisibilityTimeoutSeconds: pulumi.Int(900),
+		MessageRetentionSeconds:  pulumi.Int(345600),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []policyStatement{
+		allowQueueConsume(args.Queue),
+		allowTables([]string{"UpdateItem", "PutItem"}, c.Tables.EventCounts, c.Tables.Actors, c.Tables.Repos, c.Tables.Leaderboard, c.Tables.RepoActivity),
+		allowTables([]string{"Scan"}, c.RulesTable),
+		allowQueueSend(c.EnrichmentQueue),
+		allowStreamPut(args.ArchiveStream),
+	}
+	// Alert rules only deliver to the configured topics and queues
+	if len(args.RuleTargets.Topics) > 0 {
+		statements = append(statements, policyStatement{Actions: []string{"sns:Publish"}, Resources: pulumi.ToStringArray(args.RuleTargets.Topics)})
+	}
+	if len(args.RuleTargets.Queues) > 0 {
+		statements = append(statements, policyStatement{Actions: []string{"sqs:SendMessage"}, Resources: pulumi.ToStringArray(args.RuleTargets.queueArns())})
+	}
+	consumerRole, err := c.newLambdaRole(ctx, "githubEventsConsumerRole", statements...)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := pulumi.StringMap{
+		"ACTORS_TABLE":               c.Tables.Actors.Name,
+		"EVENTS_COUNT_TABLE":         c.Tables.EventCounts.Name,
+		"REPOS_TABLE":                c.Tables.Repos.Name,
+		"LEADERBOARD_TABLE":          c.Tables.Leaderboard.Name,
+		"REPO_ACTIVITY_TABLE":        c.Tables.RepoActivity.Name,
+		"RULES_TABLE":                c.RulesTable.Name,
+		"ACTOR_ENRICHMENT_QUEUE_URL": c.EnrichmentQueue.Url,
+		"ARCHIVE_STREAM_NAME":        args.ArchiveStream.Name,
+	}
+	if targets := args.RuleTargets.list(); targets != "" {
+		variables["RULE_TARGETS"] = pulumi.String(targets)
+	}
+	c.Consumer, err = c.newFunction(ctx, "githubEventsConsumer", args.Consumer, &lambda.FunctionArgs{
+		Role:        consumerRole.Arn,
+		Environment: &lambda.FunctionEnvironmentArgs{Variables: variables},
+	}, pulumi.DependsOn([]pulumi.Resource{args.Queue, c.Tables.Actors, c.Tables.Repos, c.Tables.EventCounts, c.Tables.Leaderboard, c.Tables.RepoActivity, c.RulesTable, c.EnrichmentQueue, args.ArchiveStream}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Enable triggering of the consumer by the SQS queue
+	_, err = lambda.NewEventSourceMapping(ctx, c.resourceName("invokeGithubEventsConsumerLambda"), &lambda.EventSourceMappingArgs{
+		EventSourceArn: args.Queue.Arn,
+		FunctionName:   c.Consumer.Name,
+		BatchSize:      pulumi.Int(args.BatchSize),
+		Enabled:        pulumi.Bool(!args.PauseConsumer),
+		// SQS batches of more than 10 events need a batching window
+		MaximumBatchingWindowInSeconds: batchingWindow(args.BatchSize),
+		// Only the events the consumer failed to save are delivered again
+		FunctionResponseTypes: pulumi.StringArray{pulumi.String("ReportBatchItemFailures")},
+	}, c.childOptions("invokeGithubEventsConsumerLambda", pulumi.DependsOn([]pulumi.Resource{c.Consumer}))...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.RegisterResourceOutputs(c, pulumi.Map{
+		"actorsTable":     c.Tables.Actors.Name,
+		"rulesTable":      c.RulesTable.Name,
+		"enrichmentQueue": c.EnrichmentQueue.Url,
+		"consumer":        c.Consumer.Arn,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// batchingWindow waits up to a second for SQS batches of more than 10 events
+// to fill, the smaller ones are sent as soon as they are polled
+func batchingWindow(batchSize int) pulumi.IntPtrInput {
+	if batchSize <= 10 {
+		return nil
+	}
+	return pulumi.Int(1)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/appsync"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/dynamodb"
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/lambda"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type QueryApiArgs struct {
+	// Live aggregate tables
+	Tables        *aggregateTables
+	TrendingTable *dynamodb.Table
+	RulesTable    *dynamodb.Table
+	// RFC 3339 expiry of the API key
+	ApiKeyExpiry pulumi.StringInput
+	// SNS topics and SQS queues alert rules may deliver to
+	RuleTargets ruleTargets
+	Resolver    lambdaSettings
+}
+
+// QueryApi serves the aggregates and manages the alert rules through an
+// AppSync GraphQL API resolved by a lambda
+type QueryApi struct {
+	pulumi.ResourceState
+	component
+
+	Api      *appsync.GraphQLApi
+	Resolver *lambda.Function
+	Url      pulumi.StringOutput
+}
+
+func NewQueryApi(ctx *pulumi.Context, name string, args *QueryApiArgs, opts ...pulumi.ResourceOption) (*QueryApi, error) {
+	c := &QueryApi{}
+	c.component = component{name: name, resource: c}
+	err := ctx.RegisterComponentResource("pointfive:pipeline:QueryApi", name, c, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	resolverRole, err := c.newLambdaRole(ctx, "resolverRole",
+		allowTables([]string{"Query", "GetItem"}, args.Tables.EventCounts, args.Tables.Actors, args.Tables.Repos, args.Tables.Leaderboard, args.TrendingTable),
+		allowTables([]string{"Scan", "PutItem", "DeleteItem"}, args.RulesTable),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := pulumi.StringMap{
+		"ACTORS_TABLE":       args.Tables.Actors.Name,
+		"EVENTS_COUNT_TABLE": args.Tables.EventCounts.Name,
+		"REPOS_TABLE":        args.Tables.Repos.Name,
+		"LEADERBOARD_TABLE":  args.Tables.Leaderboard.Name,
+		"TRENDING_TABLE":     args.TrendingTable.Name,
+		"RULES_TABLE":        args.RulesTable.Name,
+	}
+	// Rules targeting other topics and queues are rejected
+	if targets := args.RuleTargets.list(); targets != "" {
+		variables["RULE_TARGETS"] = pulumi.String(targets)
+	}
+	c.Resolver, err = c.newFunction(ctx, "resolverLambdaFunction", args.Resolver, &lambda.FunctionArgs{
+		Role:        resolverRole.Arn,
+		Environment: &lambda.FunctionEnvironmentArgs{Variables: variables},
+	}, pulumi.DependsOn([]pulumi.Resource{args.Tables.Actors, args.Tables.Repos, args.Tables.EventCounts, args.Tables.Leaderboard, args.TrendingTable, args.RulesTable}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.Api, err = appsync.NewGraphQLApi(ctx, c.resourceName("api"), &appsync.GraphQLApiArgs{
+		Schema:             pulumi.String(graphQLSchema),
+		AuthenticationType: pulumi.String("API_KEY"),
+		// The @aws_iam fields managing the alert rules
+		AdditionalAuthenticationProviders: appsync.GraphQLApiAdditionalAuthenticationProviderArray{
+			&appsync.GraphQLApiAdditionalAuthenticationProviderArgs{
+				AuthenticationType: pulumi.String("AWS_IAM"),
+			},
+		},
+	}, c.childOptions("api")...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate an API Key for the newly created AppSync API for public access.
+	_, err = appsync.NewApiKey(ctx, c.resourceName("myApiKey"), &appsync.ApiKeyArgs{
+		ApiId:   c.Api.ID(), // Associate to the new API
+		Expires: args.ApiKeyExpiry,
+	}, c.childOptions("myApiKey")...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	appSyncRole, err := newServiceRole(ctx, c.resourceName("appSyncRole"), "appsync.amazonaws.com",
+		[]policyStatement{allowFunctionInvoke(c.Resolver)}, c.childOptions("appSyncRole")...)
+	if err != nil {
+		return nil, err
+	}
+
+	dataSource, err := appsync.NewDataSource(ctx, c.resourceName("dataSource"), &appsync.DataSourceArgs{
+		ApiId: c.Api.ID(),
+		Name:  pulumi.String("lambda"),
+		Type:  pulumi.String("AWS_LAMBDA"),
+		LambdaConfig: &appsync.DataSourceLambdaConfigArgs{
+			FunctionArn: c.Resolver.Arn,
+		},
+		ServiceRoleArn: appSyncRole.Arn,
+	}, c.childOptions("dataSource", pulumi.DependsOn([]pulumi.Resource{c.Resolver, c.Api}))...)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string][]string{
+		"Query":    {"Repos", "Actors", "Events", "topRepos", "topActors", "topEventTypes", "eventsByLanguage", "trendingRepos", "rules"},
+		"Mutation": {"createRule", "deleteRule"},
+	}
+	for _, typeName := range []string{"Query", "Mutation"} {
+		for _, field := range fields[typeName] {
+			err = c.newLambdaResolver(ctx, dataSource, typeName, field)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	c.Url = c.Api.Uris.MapIndex(pulumi.String("GRAPHQL"))
+	err = ctx.RegisterResourceOutputs(c, pulumi.Map{
+		"apiId":    c.Api.ID(),
+		"url":      c.Url,
+		"resolver": c.Resolver.Arn,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newLambdaResolver resolves the field with the API lambda, passing it the
+// field name and its arguments
+func (c *QueryApi) newLambdaResolver(ctx *pulumi.Context, dataSource *appsync.DataSource, typeName string, field string) error {
+	_, err := appsync.NewResolver(ctx, c.resourceName("resolver_"+field), &appsync.ResolverArgs{
+		ApiId:      c.Api.ID(),
+		Type:       pulumi.String(typeName),
+		Field:      pulumi.String(field),
+		DataSource: dataSource.Name,
+		RequestTemplate: pulumi.String(`{
+                    "version": "2017-02-28",
+                    "operation": "Invoke",
+                    "payload": {
+                      "field": "` + field + `",
+                      "arguments": $util.toJson($context.arguments)
+                    }
+                  }`),
+		ResponseTemplate: pulumi.String("$util.toJson($context.result)"),
+	}, c.childOptions("resolver_"+field)...)
+	return err
+}
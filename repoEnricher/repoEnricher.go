@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/google/go-github/v55/github"
+)
+
+var reposTableName string
+
+var db *dynamodb.DynamoDB
+var metrics = common.NewMetrics()
+var logger = common.NewLogger()
+var client *github.Client
+
+// Repos are refreshed again once their metadata is older than this
+const staleAfter = 6 * time.Hour
+
+// Most repos a single run refreshes
+const maxRefreshes = 300
+
+// Only repos active within this window are refreshed, which bounds the
+// range of the activity index a run reads
+const activeWithin = 24 * time.Hour
+
+// GitHub requests left to other consumers of the token when a run stops
+const rateLimitReserve = 100
+
+var errRateLimited = errors.New("github rate limit reserve reached")
+
+type staleRepo struct {
+	RepoUrl string
+	RepoId  int64
+}
+
+func main() {
+	reposTableName = os.Getenv("REPOS_TABLE")
+	if reposTableName == "" {
+		logger.Error("REPOS_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("REPOS_TABLE is set", "value", reposTableName)
+
+	sess := session.Must(session.NewSession())
+	client = github.NewClient(nil)
+	// Unauthenticated requests are limited to 60 an hour
+	if tokenParameter := os.Getenv("GITHUB_TOKEN_PARAMETER"); tokenParameter != "" {
+		token, err := common.ReadSecret(sess, tokenParameter)
+		if err != nil {
+			logger.Error("failed to read the GitHub token", "parameter", tokenParameter, "error", err)
+			os.Exit(1)
+		}
+		client = client.WithAuthToken(token)
+	} else {
+		logger.Warn("GITHUB_TOKEN_PARAMETER is not set, using unauthenticated requests")
+	}
+
+	db = dynamodb.New(sess)
+	metrics.TrackLatency(&db.Handlers, "DynamoDBLatency")
+
+	lambda.Start(handler)
+}
+
+// handler refreshes the metadata of the most recently active repos whose
+// metadata is missing or stale, until the run or rate limit budget is spent
+func handler(ctx context.Context) error {
+	defer metrics.Flush()
+	log := common.RequestLogger(ctx, logger)
+	now := time.Now()
+	repos, err := getStaleRepos(now.Add(-activeWithin), now.Add(-staleAfter), maxRefreshes)
+	if err != nil {
+		log.Error("failed to get stale repos", "error", err)
+		return err
+	}
+
+	refreshed := 0
+	for _, repo := range repos {
+		err = refreshRepo(ctx, repo)
+		if errors.Is(err, errRateLimited) {
+			log.Warn("stopping, github rate limit reserve reached")
+			break
+		}
+		if err != nil {
+			log.Error("failed to refresh repo", "repo", repo.RepoUrl, "error", err)
+			continue
+		}
+		refreshed++
+	}
+	log.Info("done refreshing repos", "stale", len(repos), "refreshed", refreshed)
+	return nil
+}
+
+// getStaleRepos returns the repos active since activeSince whose metadata is
+// missing or older than staleBefore, the most recently active first
+func getStaleRepos(activeSince time.Time, staleBefore time.Time, limit int) ([]staleRepo, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(reposTableName),
+		IndexName:              aws.String(common.ReposByLastActivityIndex),
+		KeyConditionExpression: aws.String("Kind = :kind AND LastActivity >= :activeSince"),
+		FilterExpression:       aws.String("RepoId > :zero AND (attribute_not_exists(RefreshedAt) OR RefreshedAt < :staleBefore)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":kind":        {S: aws.String(common.KindRepo)},
+			":activeSince": {N: aws.String(fmt.Sprintf("%d", activeSince.Unix()))},
+			":zero":        {N: aws.String("0")},
+			":staleBefore": {N: aws.String(fmt.Sprintf("%d", staleBefore.Unix()))},
+		},
+		ProjectionExpression: aws.String("RepoUrl, RepoId"),
+		ScanIndexForward:     aws.Bool(false),
+	}
+
+	repos := []staleRepo{}
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			repo := staleRepo{}
+			err := dynamodbattribute.UnmarshalMap(i, &repo)
+			if err != nil {
+				continue
+			}
+			repos = append(repos, repo)
+			if len(repos) == limit {
+				return false
+			}
+		}
+		return true
+	})
+	return repos, err
+}
+
+// refreshRepo fetches the repo from GitHub and saves its metadata. Repos
+// which no longer exist are marked refreshed so they are not retried every run.
+func refreshRepo(ctx context.Context, repo staleRepo) error {
+	repository, response, err := client.Repositories.GetByID(ctx, repo.RepoId)
+	if response != nil && response.StatusCode == http.StatusNotFound {
+		return saveRefreshedAt(repo)
+	}
+	var rateLimitErr *github.RateLimitError
+	var abuseRateLimitErr *github.AbuseRateLimitError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseRateLimitErr) {
+		return errRateLimited
+	}
+	if err != nil {
+		return err
+	}
+
+	err = saveRepo(repo, repository)
+	if err != nil {
+		return err
+	}
+	if response.Rate.Remaining < rateLimitReserve {
+		return errRateLimited
+	}
+	return nil
+}
+
+func saveRepo(repo staleRepo, repository *github.Repository) error {
+	topics, err := dynamodbattribute.Marshal(repository.Topics)
+	if err != nil {
+		return err
+	}
+	if repository.Topics == nil {
+		topics = &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{}}
+	}
+
+	updateExpression := "SET Stars = :stars, Forks = :forks, #language = :language, Topics = :topics, #description = :description, License = :license, OpenIssues = :openIssues, DefaultBranch = :defaultBranch, RepoCreatedAt = :repoCreatedAt, RefreshedAt = :refreshedAt"
+	expressionAttributeNames := map[string]*string{
+		"#language":    aws.String("Language"),
+		"#description": aws.String("Description"),
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":stars": {
+			N: aws.String(strconv.Itoa(repository.GetStargazersCount())),
+		},
+		":forks": {
+			N: aws.String(strconv.Itoa(repository.GetForksCount())),
+		},
+		":language": {
+			S: aws.String(repository.GetLanguage()),
+		},
+		":topics": topics,
+		":description": {
+			S: aws.String(repository.GetDescription()),
+		},
+		":license": {
+			S: aws.String(repository.GetLicense().GetSPDXID()),
+		},
+		":openIssues": {
+			N: aws.String(strconv.Itoa(repository.GetOpenIssuesCount())),
+		},
+		":defaultBranch": {
+			S: aws.String(repository.GetDefaultBranch()),
+		},
+		":repoCreatedAt": {
+			N: aws.String(fmt.Sprintf("%d", repository.GetCreatedAt().Unix())),
+		},
+		":refreshedAt": {
+			N: aws.String(fmt.Sprintf("%d", time.Now().Unix())),
+		},
+	}
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(reposTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"RepoUrl": {S: aws.String(repo.RepoUrl)},
+		},
+		UpdateExpression:          aws.String(updateExpression),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		ReturnValues:              aws.String("NONE"),
+	}
+
+	_, err = db.UpdateItem(updateInput)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func saveRefreshedAt(repo staleRepo) error {
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(reposTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"RepoUrl": {S: aws.String(repo.RepoUrl)},
+		},
+		UpdateExpression: aws.String("SET RefreshedAt = :refreshedAt"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":refreshedAt": {N: aws.String(fmt.Sprintf("%d", time.Now().Unix()))},
+		},
+		ReturnValues: aws.String("NONE"),
+	}
+
+	_, err := db.UpdateItem(updateInput)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+// graphQLSchema is the schema of the QueryApi, every field is resolved by the
+// API lambda. The reads take the API key, the alert rules are only managed
+// with IAM credentials
+const graphQLSchema = `
+                type Repo {
+                  repoURL: String
+                  repoName: String
+									repoId: Int
+                  stars: Int
+                  forks: Int
+                  language: String
+                  topics: [String]
+                  description: String
+                  license: String
+                  openIssues: Int
+                  defaultBranch: String
+                  createdAt: Int
+                  refreshedAt: Int
+                }
+                
+                type Actor {
+                  login: String
+                  name: String
+                  email: String
+                  company: String
+                  location: String
+                  followers: Int
+                  accountType: String
+                  profileFetchedAt: Int
+                  isBot: Boolean
+                  botReason: String
+                }
+
+                type Event {
+                  type: String
+                  count: Int
+                }
+
+                enum SortOrder {
+                  ASC
+                  DESC
+                }
+
+                enum RepoSort {
+                  LAST_ACTIVITY
+                  NAME
+                }
+
+                enum ActorSort {
+                  LAST_ACTION
+                  LOGIN
+                }
+
+                enum EventSort {
+                  COUNT
+                  TYPE
+                }
+
+                type PageInfo {
+                  hasNextPage: Boolean!
+                  endCursor: String
+                }
+
+                type RepoEdge {
+                  node: Repo
+                  cursor: String
+                }
+
+                type RepoConnection {
+                  edges: [RepoEdge]
+                  pageInfo: PageInfo!
+                }
+
+                type ActorEdge {
+                  node: Actor
+                  cursor: String
+                }
+
+                type ActorConnection {
+                  edges: [ActorEdge]
+                  pageInfo: PageInfo!
+                }
+
+                type EventEdge {
+                  node: Event
+                  cursor: String
+                }
+
+                type EventConnection {
+                  edges: [EventEdge]
+                  pageInfo: PageInfo!
+                }
+
+                type LeaderboardEntry {
+                  key: String
+                  count: Int
+                }
+
+                enum Window {
+                  HOUR
+                  DAY
+                  ALL
+                }
+
+                type TrendingRepo {
+                  repoName: String
+                  score: Float
+                  recent: Float
+                  baseline: Float
+                }
+
+                enum TrendWindow {
+                  HOUR
+                  DAY
+                }
+
+                enum TargetType {
+                  SNS
+                  SQS
+                  WEBHOOK
+                }
+
+                type Rule @aws_iam {
+                  ruleId: String
+                  name: String
+                  expression: String
+                  targetType: TargetType
+                  target: String
+                  createdAt: Int
+                }
+
+                type Query {
+                  Repos(first: Int, after: String, excludeBots: Boolean, namePrefix: String, eventType: String, since: Int, until: Int, minStars: Int, sortBy: RepoSort, order: SortOrder): RepoConnection
+                  Actors(first: Int, after: String, excludeBots: Boolean, namePrefix: String, since: Int, until: Int, sortBy: ActorSort, order: SortOrder): ActorConnection
+                  Events(first: Int, after: String, excludeBots: Boolean, namePrefix: String, eventType: String, sortBy: EventSort, order: SortOrder): EventConnection
+                  topRepos(limit: Int, window: Window, excludeBots: Boolean): [LeaderboardEntry]
+                  topActors(limit: Int, window: Window, excludeBots: Boolean): [LeaderboardEntry]
+                  topEventTypes(limit: Int, window: Window, excludeBots: Boolean): [LeaderboardEntry]
+                  eventsByLanguage(limit: Int, window: Window, excludeBots: Boolean): [LeaderboardEntry]
+                  trendingRepos(window: TrendWindow, limit: Int, excludeBots: Boolean): [TrendingRepo]
+                  rules: [Rule] @aws_iam
+                }
+
+                type Mutation {
+                  createRule(name: String!, expression: String!, targetType: TargetType!, target: String!): Rule @aws_iam
+                  deleteRule(ruleId: String!): Boolean @aws_iam
+                }`
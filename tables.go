@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v4/go/aws/dynamodb"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// aggregateTables are the tables the consumer aggregates events into. The
+// replay command rebuilds them into a second, shadow set which is then
+// swapped in with the aggregateTables config
+type aggregateTables struct {
+	Actors       *dynamodb.Table
+	EventCounts  *dynamodb.Table
+	Repos        *dynamodb.Table
+	Leaderboard  *dynamodb.Table
+	RepoActivity *dynamodb.Table
+}
+
+// Table sets selected by the aggregateTables config
+const (
+	primaryTables = "primary"
+	shadowTables  = "shadow"
+)
+
+// newAggregateTables creates a set of aggregate tables, the suffix tells the
+// shadow set resources from the primary ones
+func newAggregateTables(ctx *pulumi.Context, c *component, settings tableSettings, suffix string) (*aggregateTables, error) {
+	// The ordered indexes are partitioned by the constant Kind attribute of
+	// the items so the API can Query them in order instead of scanning
+	actorsTable, err := dynamodb.NewTable(ctx, c.resourceName("actorsTable"+suffix), settings.apply(&dynamodb.TableArgs{
+		Attributes: dynamodb.TableAttributeArray{
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Login"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Kind"),
+				Type: pulumi.String("S"),
+			},
+			dynamodb.TableAttributeArgs{
+				Name: pulumi.String("LastAction"),
+				Type: pulumi.String("N"),
+			},
+		},
+		HashKey: pulumi.String("Login"),
+		GlobalSecondaryIndexes: dynamodb.TableGlobalSecondaryIndexArray{
+			&dynamodb.TableGlobalSecondaryIndexArgs{
+				Name:           pulumi.String("ByLastAction"),
+				HashKey:        pulumi.String("Kind"),
+				RangeKey:       pulumi.String("LastAction"),
+				ProjectionType: pulumi.String("ALL"),
+			},
+			&dynamodb.TableGlobalSecondaryIndexArgs{
+				Name:           pulumi.String("ByLogin"),
+				HashKey:        pulumi.String("Kind"),
+				RangeKey:       pulumi.String("Login"),
+				ProjectionType: pulumi.String("ALL"),
+			},
+		},
+		TableClass: pulumi.String("STANDARD"),
+	}), c.childOptions("actorsTable"+suffix)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	eventCountTable, err := dynamodb.NewTable(ctx, c.resourceName("EventsCounts"+suffix), settings.apply(&dynamodb.TableArgs{
+		Attributes: dynamodb.TableAttributeArray{
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("EventType"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Kind"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Count"),
+				Type: pulumi.String("N"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("HumanCount"),
+				Type: pulumi.String("N"),
+			},
+		},
+		HashKey: pulumi.String("EventType"),
+		GlobalSecondaryIndexes: dynamodb.TableGlobalSecondaryIndexArray{
+			&dynamodb.TableGlobalSecondaryIndexArgs{
+				Name:           pulumi.String("ByCount"),
+				HashKey:        pulumi.String("Kind"),
+				RangeKey:       pulumi.String("Count"),
+				ProjectionType: pulumi.String("ALL"),
+			},
+			// Orders the events of human actors, for excludeBots
+			&dynamodb.TableGlobalSecondaryIndexArgs{
+				Name:           pulumi.String("ByHumanCount"),
+				HashKey:        pulumi.String("Kind"),
+				RangeKey:       pulumi.String("HumanCount"),
+				ProjectionType: pulumi.String("ALL"),
+			},
+			&dynamodb.TableGlobalSecondaryIndexArgs{
+				Name:           pulumi.String("ByType"),
+				HashKey:        pulumi.String("Kind"),
+				RangeKey:       pulumi.String("EventType"),
+				ProjectionType: pulumi.String("ALL"),
+			},
+		},
+		TableClass: pulumi.String("STANDARD"),
+	}), c.childOptions("EventsCounts"+suffix)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reposTable, err := dynamodb.NewTable(ctx, c.resourceName("ReposTable"+suffix), settings.apply(&dynamodb.TableArgs{
+		Attributes: dynamodb.TableAttributeArray{
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("RepoUrl"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Kind"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("LastActivity"),
+				Type: pulumi.String("N"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("RepoName"),
+				Type: pulumi.String("S"),
+			},
+		},
+		HashKey: pulumi.String("RepoUrl"),
+		GlobalSecondaryIndexes: dynamodb.TableGlobalSecondaryIndexArray{
+			&dynamodb.TableGlobalSecondaryIndexArgs{
+				Name:           pulumi.String("ByLastActivity"),
+				HashKey:        pulumi.String("Kind"),
+				RangeKey:       pulumi.String("LastActivity"),
+				ProjectionType: pulumi.String("ALL"),
+			},
+			&dynamodb.TableGlobalSecondaryIndexArgs{
+				Name:           pulumi.String("ByName"),
+				HashKey:        pulumi.String("Kind"),
+				RangeKey:       pulumi.String("RepoName"),
+				ProjectionType: pulumi.String("ALL"),
+			},
+		},
+		TableClass: pulumi.String("STANDARD"),
+	}), c.childOptions("ReposTable"+suffix)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Top-N leaderboards per time window, sorted by the CountIndex. A global
+	// index has no size limit per Board, unlike a local one (10 GB)
+	leaderboardTable, err := dynamodb.NewTable(ctx, c.resourceName("LeaderboardTable"+suffix), settings.apply(&dynamodb.TableArgs{
+		Attributes: dynamodb.TableAttributeArray{
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Board"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Member"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Count"),
+				Type: pulumi.String("N"),
+			},
+		},
+		HashKey:  pulumi.String("Board"),
+		RangeKey: pulumi.String("Member"),
+		GlobalSecondaryIndexes: dynamodb.TableGlobalSecondaryIndexArray{
+			&dynamodb.TableGlobalSecondaryIndexArgs{
+				Name:           pulumi.String("CountIndex"),
+				HashKey:        pulumi.String("Board"),
+				RangeKey:       pulumi.String("Count"),
+				ProjectionType: pulumi.String("ALL"),
+			},
+		},
+		Ttl: &dynamodb.TableTtlArgs{
+			AttributeName: pulumi.String("ExpiresAt"),
+			Enabled:       pulumi.Bool(true),
+		},
+		TableClass: pulumi.String("STANDARD"),
+	}), c.childOptions("LeaderboardTable"+suffix)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Hourly weighted activity per repo used to compute trending scores
+	repoActivityTable, err := dynamodb.NewTable(ctx, c.resourceName("RepoActivityTable"+suffix), settings.apply(&dynamodb.TableArgs{
+		Attributes: dynamodb.TableAttributeArray{
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("RepoName"),
+				Type: pulumi.String("S"),
+			},
+			&dynamodb.TableAttributeArgs{
+				Name: pulumi.String("Bucket"),
+				Type: pulumi.String("S"),
+			},
+		},
+		HashKey:  pulumi.String("RepoName"),
+		RangeKey: pulumi.String("Bucket"),
+		Ttl: &dynamodb.TableTtlArgs{
+			AttributeName: pulumi.String("ExpiresAt"),
+			Enabled:       pulumi.Bool(true),
+		},
+		TableClass: pulumi.String("STANDARD"),
+	}), c.childOptions("RepoActivityTable"+suffix)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &aggregateTables{
+		Actors:       actorsTable,
+		EventCounts:  eventCountTable,
+		Repos:        reposTable,
+		Leaderboard:  leaderboardTable,
+		RepoActivity: repoActivityTable,
+	}, nil
+}
+
+// env maps the environment variables the consumer reads the table names from
+// to the tables of the set
+func (t *aggregateTables) env() pulumi.StringMap {
+	return pulumi.StringMap{
+		"ACTORS_TABLE":        t.Actors.Name,
+		"EVENTS_COUNT_TABLE":  t.EventCounts.Name,
+		"REPOS_TABLE":         t.Repos.Name,
+		"LEADERBOARD_TABLE":   t.Leaderboard.Name,
+		"REPO_ACTIVITY_TABLE": t.RepoActivity.Name,
+	}
+}
+
+// selectAggregateTables creates the primary set and, when enabled or live,
+// the shadow set. It returns the live set and the set replays rebuild, nil
+// when there is no shadow set
+func selectAggregateTables(ctx *pulumi.Context, c *component, settings tableSettings, live string, withShadow bool) (*aggregateTables, *aggregateTables, error) {
+	if live != primaryTables && live != shadowTables {
+		return nil, nil, fmt.Errorf("aggregateTables must be %q or %q, got %q", primaryTables, shadowTables, live)
+	}
+
+	primary, err := newAggregateTables(ctx, c, settings, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if !withShadow && live == primaryTables {
+		return primary, nil, nil
+	}
+
+	shadow, err := newAggregateTables(ctx, c, settings, "Shadow")
+	if err != nil {
+		return nil, nil, err
+	}
+	if live == shadowTables {
+		return shadow, primary, nil
+	}
+	return primary, shadow, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ahmads/common"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var leaderboardTableName string
+var repoActivityTableName string
+var trendingTableName string
+
+var db *dynamodb.DynamoDB
+var metrics = common.NewMetrics()
+var logger = common.NewLogger()
+
+// Only the busiest repos of the recent window are scored
+const maxCandidates = 500
+
+// Repos need some activity in the recent window before they can trend
+const minRecentWeight = 5
+
+func main() {
+	leaderboardTableName = os.Getenv("LEADERBOARD_TABLE")
+	if leaderboardTableName == "" {
+		logger.Error("LEADERBOARD_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("LEADERBOARD_TABLE is set", "value", leaderboardTableName)
+
+	repoActivityTableName = os.Getenv("REPO_ACTIVITY_TABLE")
+	if repoActivityTableName == "" {
+		logger.Error("REPO_ACTIVITY_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("REPO_ACTIVITY_TABLE is set", "value", repoActivityTableName)
+
+	trendingTableName = os.Getenv("TRENDING_TABLE")
+	if trendingTableName == "" {
+		logger.Error("TRENDING_TABLE environment variable not set")
+		os.Exit(1)
+	}
+	logger.Info("TRENDING_TABLE is set", "value", trendingTableName)
+
+	sess := session.Must(session.NewSession())
+	db = dynamodb.New(sess)
+	metrics.TrackLatency(&db.Handlers, "DynamoDBLatency")
+
+	lambda.Start(handler)
+}
+
+func handler(ctx context.Context) error {
+	defer metrics.Flush()
+	log := common.RequestLogger(ctx, logger)
+	now := time.Now()
+	for _, window := range common.TrendWindows {
+		for _, humanOnly := range []bool{false, true} {
+			err := aggregateWindow(log, window, humanOnly, now)
+			if err != nil {
+				log.Error("failed to aggregate trending window", "window", window.Name, "humanOnly", humanOnly, "error", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// aggregateWindow scores the candidate repos of the window and stores the
+// positive scores in the trending table. Only complete hours are compared, the
+// current hour is still filling up. The human only variant of the window
+// ignores events of bots.
+func aggregateWindow(log *slog.Logger, window common.TrendWindow, humanOnly bool, now time.Time) error {
+	windowKey := window.Name
+	if humanOnly {
+		windowKey = common.HumanOnly(window.Name)
+	}
+	log = log.With("window", windowKey)
+	log.Info("aggregating trending window")
+	end := now.UTC().Truncate(time.Hour)
+	candidates, err := getCandidates(window, humanOnly, end)
+	if err != nil {
+		return err
+	}
+
+	// Results expire after a couple of runs so repos which stopped trending drop out
+	expiresAt := now.Add(2 * time.Hour)
+	scored := 0
+	for _, repoName := range candidates {
+		recent, baseline, err := getWeightedActivity(repoName, window, humanOnly, end)
+		if err != nil {
+			return err
+		}
+		score := trendingScore(recent, baseline, window)
+		if score <= 0 {
+			continue
+		}
+		err = saveScore(windowKey, repoName, score, recent, baseline, expiresAt)
+		if err != nil {
+			return err
+		}
+		scored++
+	}
+	log.Info("done aggregating trending window", "candidates", len(candidates), "trending", scored)
+	return nil
+}
+
+// getCandidates returns the busiest repos of the leaderboards overlapping the
+// recent part of the window
+func getCandidates(window common.TrendWindow, humanOnly bool, end time.Time) ([]string, error) {
+	boards, err := candidateBoards(window, humanOnly, end)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	candidates := []string{}
+	for _, board := range boards {
+		result, err := db.Query(&dynamodb.QueryInput{
+			TableName:              aws.String(leaderboardTableName),
+			IndexName:              aws.String(common.LeaderboardByCountIndex),
+			KeyConditionExpression: aws.String("Board = :board"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":board": {S: aws.String(board)},
+			},
+			ScanIndexForward: aws.Bool(false),
+			Limit:            aws.Int64(maxCandidates),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range result.Items {
+			member := *i["Member"].S
+			if !seen[member] {
+				seen[member] = true
+				candidates = append(candidates, member)
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// candidateBoards returns the leaderboards of every bucket overlapping
+// [end-Recent, end], including the current one still filling up. Day boards
+// cover the windows of a day or more, hour boards the shorter ones.
+func candidateBoards(window common.TrendWindow, humanOnly bool, end time.Time) ([]string, error) {
+	dimension := common.BoardRepos
+	if humanOnly {
+		dimension = common.HumanOnly(common.BoardRepos)
+	}
+	boardWindow := common.WindowHour
+	step := time.Hour
+	if window.Recent >= 24*time.Hour {
+		boardWindow = common.WindowDay
+		step = 24 * time.Hour
+	}
+
+	boards := []string{}
+	end = end.UTC()
+	for t := end.Add(-window.Recent).Truncate(step); !t.After(end); t = t.Add(step) {
+		board, err := common.BoardKey(dimension, boardWindow, t)
+		if err != nil {
+			return nil, err
+		}
+		boards = append(boards, board)
+	}
+	return boards, nil
+}
+
+// getWeightedActivity sums the repo's weighted event counts in the recent
+// window and in the baseline preceding it, both ending at the hour end
+func getWeightedActivity(repoName string, window common.TrendWindow, humanOnly bool, end time.Time) (float64, float64, error) {
+	recentStart := common.HourBucket(end.Add(-window.Recent))
+	weightAttribute := "Weighted"
+	if humanOnly {
+		weightAttribute = "HumanWeighted"
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(repoActivityTableName),
+		KeyConditionExpression: aws.String("RepoName = :repoName AND Bucket BETWEEN :from AND :to"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":repoName": {S: aws.String(repoName)},
+			":from":     {S: aws.String(common.HourBucket(end.Add(-window.Recent - window.Baseline)))},
+			":to":       {S: aws.String(common.HourBucket(end.Add(-time.Hour)))},
+		},
+		ProjectionExpression: aws.String("Bucket, " + weightAttribute),
+	}
+
+	var recent, baseline float64
+	err := db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			attribute, ok := i[weightAttribute]
+			if !ok {
+				continue
+			}
+			weighted, err := strconv.ParseFloat(aws.StringValue(attribute.N), 64)
+			if err != nil {
+				continue
+			}
+			if *i["Bucket"].S >= recentStart {
+				recent += weighted
+			} else {
+				baseline += weighted
+			}
+		}
+		return true
+	})
+	return recent, baseline, err
+}
+
+func trendingScore(recent float64, baseline float64, window common.TrendWindow) float64 {
+	if recent < minRecentWeight {
+		return 0
+	}
+	recentRate := recent / window.Recent.Hours()
+	baselineRate := baseline / window.Baseline.Hours()
+	return (recentRate - baselineRate) / math.Sqrt(baselineRate+1)
+}
+
+func saveScore(window string, repoName string, score float64, recent float64, baseline float64, expiresAt time.Time) error {
+	putInput := &dynamodb.PutItemInput{
+		TableName: aws.String(trendingTableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"Window":    {S: aws.String(window)},
+			"RepoName":  {S: aws.String(repoName)},
+			"Score":     {N: aws.String(strconv.FormatFloat(score, 'f', 4, 64))},
+			"Recent":    {N: aws.String(strconv.FormatFloat(recent, 'f', -1, 64))},
+			"Baseline":  {N: aws.String(strconv.FormatFloat(baseline, 'f', -1, 64))},
+			"ExpiresAt": {N: aws.String(fmt.Sprintf("%d", expiresAt.Unix()))},
+		},
+	}
+
+	_, err := db.PutItem(putInput)
+	if err != nil {
+		return err
+	}
+	return nil
+}
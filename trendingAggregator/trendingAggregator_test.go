@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ahmads/common"
+)
+
+func TestCandidateBoards(t *testing.T) {
+	hour, _ := common.GetTrendWindow(common.WindowHour)
+	day, _ := common.GetTrendWindow(common.WindowDay)
+	end := time.Date(2023, 10, 1, 14, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		window    common.TrendWindow
+		humanOnly bool
+		end       time.Time
+		boards    []string
+	}{
+		{"hour", hour, false, end, []string{"REPO#HOUR#2023100113", "REPO#HOUR#2023100114"}},
+		{"hour of humans", hour, true, end, []string{"HUMAN_REPO#HOUR#2023100113", "HUMAN_REPO#HOUR#2023100114"}},
+		{"hour across days", hour, false, time.Date(2023, 10, 2, 0, 0, 0, 0, time.UTC), []string{"REPO#HOUR#2023100123", "REPO#HOUR#2023100200"}},
+		// The day boards of yesterday and today overlap the last 24 hours
+		{"day", day, false, end, []string{"REPO#DAY#20230930", "REPO#DAY#20231001"}},
+		{"day at midnight", day, false, time.Date(2023, 10, 2, 0, 0, 0, 0, time.UTC), []string{"REPO#DAY#20231001", "REPO#DAY#20231002"}},
+		{"day of humans", day, true, end, []string{"HUMAN_REPO#DAY#20230930", "HUMAN_REPO#DAY#20231001"}},
+		{"end in another time zone", hour, false, end.In(time.FixedZone("UTC+2", 2*60*60)), []string{"REPO#HOUR#2023100113", "REPO#HOUR#2023100114"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			boards, err := candidateBoards(test.window, test.humanOnly, test.end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(boards, test.boards) {
+				t.Errorf("boards = %v, want %v", boards, test.boards)
+			}
+		})
+	}
+}
+
+func TestTrendingScore(t *testing.T) {
+	hour, _ := common.GetTrendWindow(common.WindowHour)
+	day, _ := common.GetTrendWindow(common.WindowDay)
+
+	tests := []struct {
+		name             string
+		recent, baseline float64
+		window           common.TrendWindow
+		score            float64
+	}{
+		{"below the minimum recent weight", 4, 0, hour, 0},
+		{"new repo", 5, 0, hour, 5},
+		{"hourly burst", 10, 24, hour, 9 / math.Sqrt(2)},
+		{"steady", 24, 576, hour, 0},
+		{"declining", 10, 480, hour, -10 / math.Sqrt(21)},
+		{"daily growth", 48, 168, day, 1 / math.Sqrt(2)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			score := trendingScore(test.recent, test.baseline, test.window)
+			if math.Abs(score-test.score) > 1e-9 {
+				t.Errorf("score = %v, want %v", score, test.score)
+			}
+		})
+	}
+}